@@ -0,0 +1,85 @@
+// Package checkpoint durably records how much input has been delivered,
+// so that an interrupted run can be resumed.
+package checkpoint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// State is the progress made through the input.
+type State struct {
+	// Offset is how many bytes of input have been consumed.
+	Offset int64 `json:"offset"`
+
+	// Chunks is how many chunks of data have been delivered.
+	Chunks int64 `json:"chunks"`
+}
+
+// Load reads the state recorded in a checkpoint file.
+// Returns an empty state if the file doesn't exist.
+func Load(path string) (State, error) {
+	var s State
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	return s, err
+}
+
+// New instantiates a checkpoint File.
+func New(path string) *File {
+	return &File{path}
+}
+
+// A File is a checkpoint file.
+type File struct {
+	path string
+}
+
+// Save durably replaces the state recorded in the checkpoint file.
+// The state is written to a temporary file which is then renamed over the checkpoint file,
+// so that the checkpoint is never left partially written.
+func (f *File) Save(s State) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(f.path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes a directory's entries to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,61 @@
+package checkpoint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint")
+	s, err := Load(path)
+	if err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+	if diff := pretty.Compare(s, State{}); diff != "" {
+		t.Errorf("Load() -got +want:\n%v", diff)
+	}
+	f := New(path)
+	for _, want := range []State{{Offset: 10, Chunks: 2}, {Offset: 123, Chunks: 45}} {
+		if err := f.Save(want); err != nil {
+			t.Errorf("Save(%v) error = %v", want, err)
+		}
+		got, err := Load(path)
+		if err != nil {
+			t.Errorf("Load() error = %v", err)
+		}
+		if diff := pretty.Compare(got, want); diff != "" {
+			t.Errorf("Load() -got +want:\n%v", diff)
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("ReadDir() = %v entries, want 1", len(entries))
+	}
+}
+
+func TestLoad_error(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint")
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load() error = nil")
+	}
+}
@@ -0,0 +1,84 @@
+// Package config parses settings files that can be reloaded at runtime.
+//
+// A settings file has one "key = value" setting per line, blank lines and lines starting with # are ignored:
+//
+//	# Throttle down the backfill.
+//	interval = 500ms
+//	byte_rate = 1048576
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings is a set of runtime-adjustable settings.
+type Settings struct {
+	// Interval is how long to wait after the throttler is ready before outputting the next data chunk.
+	Interval time.Duration
+
+	// Rate is the maximum number of data chunks to output per second.
+	Rate float64
+
+	// ByteRate is the maximum number of bytes to output per second.
+	ByteRate float64
+
+	// Burst is how many chunks can be output in a burst.
+	Burst int
+
+	// ByteBurst is how many bytes can be output in a burst.
+	ByteBurst int
+}
+
+// Load parses a settings file, settings missing from the file keep their value in s.
+func Load(path string, s Settings) (Settings, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Settings{}, err
+	}
+	defer f.Close()
+	return Parse(f, s)
+}
+
+// Parse parses settings from a reader, settings missing from the reader keep their value in s.
+func Parse(r io.Reader, s Settings) (Settings, error) {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return s, fmt.Errorf("line %v: missing '='", n)
+		}
+		if err := s.set(strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])); err != nil {
+			return s, fmt.Errorf("line %v: %w", n, err)
+		}
+	}
+	return s, sc.Err()
+}
+
+func (s *Settings) set(key, value string) error {
+	var err error
+	switch key {
+	case "interval":
+		s.Interval, err = time.ParseDuration(value)
+	case "rate":
+		s.Rate, err = strconv.ParseFloat(value, 64)
+	case "byte_rate":
+		s.ByteRate, err = strconv.ParseFloat(value, 64)
+	case "burst":
+		s.Burst, err = strconv.Atoi(value)
+	case "byte_burst":
+		s.ByteBurst, err = strconv.Atoi(value)
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return err
+}
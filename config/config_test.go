@@ -0,0 +1,97 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParse(t *testing.T) {
+	testdata := []struct {
+		name  string
+		input string
+		want  Settings
+		ok    bool
+	}{
+		{
+			name: "empty",
+			want: Settings{Rate: 100},
+			ok:   true,
+		},
+		{
+			name:  "all",
+			input: "# comment\ninterval = 1.5s\n\nrate=500\n  byte_rate = 2097152  \nburst = 10\nbyte_burst = 4194304\n",
+			want: Settings{
+				Interval:  1500 * time.Millisecond,
+				Rate:      500,
+				ByteRate:  2097152,
+				Burst:     10,
+				ByteBurst: 4194304,
+			},
+			ok: true,
+		},
+		{
+			name:  "partial",
+			input: "interval = 1s\n",
+			want: Settings{
+				Interval: time.Second,
+				Rate:     100,
+			},
+			ok: true,
+		},
+		{
+			name:  "missing equals",
+			input: "interval 1s\n",
+		},
+		{
+			name:  "unknown",
+			input: "foo = bar\n",
+		},
+		{
+			name:  "bad value",
+			input: "rate = fast\n",
+		},
+	}
+	for _, tt := range testdata {
+		got, err := Parse(strings.NewReader(tt.input), Settings{Rate: 100})
+		if err != nil {
+			if tt.ok {
+				t.Errorf("Parse(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("Parse(%v) error = nil", tt.name)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("Parse(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pt.conf")
+	if _, err := Load(path, Settings{}); err == nil {
+		t.Error("Load(missing) error = nil")
+	}
+	if err := ioutil.WriteFile(path, []byte("rate = 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path, Settings{Interval: time.Second})
+	if err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+	if s.Rate != 2 || s.Interval != time.Second {
+		t.Errorf("Load() = %+v", s)
+	}
+}
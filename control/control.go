@@ -0,0 +1,209 @@
+// Package control implements a line-based protocol to control a running Runner,
+// typically over a Unix-domain socket.
+//
+// Each request is a single line with a command and its arguments, separated by whitespace.
+// Each response is a single line starting with either "ok" or "error", followed by any results.
+// Supported commands are:
+//
+//	status                                                     report progress and settings
+//	pause                                                      pause output after the current chunk
+//	resume                                                     resume output
+//	step                                                       output one more chunk while paused
+//	skip N                                                     discard the next N chunks
+//	interval DURATION                                          change the interval between chunks
+//	rate CHUNKS_PER_SEC [BYTES_PER_SEC [BURST [BYTE_BURST]]]   change the rate limits, 0 is unlimited
+//	drain                                                      stop reading input and exit after the current chunk
+package control
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/runner"
+	"github.com/hazaelsan/pipe-throttler/throttler/ratelimit"
+)
+
+// ErrNoRateLimit is returned when changing rate limits without a rate limiter.
+var ErrNoRateLimit = errors.New("rate limiting not enabled")
+
+// A Runner is a controllable runner.Runner.
+type Runner interface {
+	Pause()
+	Resume()
+	Step()
+	Skip(int64)
+	Drain()
+	SetWaitDuration(time.Duration)
+	Status() runner.Status
+}
+
+// A RateLimiter is a throttler whose rate limits can be changed.
+type RateLimiter interface {
+	SetLimits(ratelimit.Options)
+}
+
+// New instantiates a control Server, rl may be nil if rate limits can't be changed.
+func New(r Runner, rl RateLimiter) *Server {
+	return &Server{r: r, rl: rl}
+}
+
+// A Server handles control requests.
+type Server struct {
+	r  Runner
+	rl RateLimiter
+}
+
+// Serve accepts connections and handles their requests until the listener is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		if _, err := fmt.Fprintln(conn, s.Handle(sc.Text())); err != nil {
+			return
+		}
+	}
+}
+
+// Handle handles a single request line, returns the response line.
+func (s *Server) Handle(line string) string {
+	resp, err := s.handle(strings.Fields(line))
+	if err != nil {
+		return "error " + err.Error()
+	}
+	if resp == "" {
+		return "ok"
+	}
+	return "ok " + resp
+}
+
+func (s *Server) handle(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("empty command")
+	}
+	cmd, args := args[0], args[1:]
+	nargs := map[string][2]int{
+		"status":   {0, 0},
+		"pause":    {0, 0},
+		"resume":   {0, 0},
+		"step":     {0, 0},
+		"skip":     {1, 1},
+		"interval": {1, 1},
+		"rate":     {1, 4},
+		"drain":    {0, 0},
+	}
+	n, ok := nargs[cmd]
+	if !ok {
+		return "", fmt.Errorf("unknown command %q", cmd)
+	}
+	if len(args) < n[0] || len(args) > n[1] {
+		return "", fmt.Errorf("wrong number of arguments for %v", cmd)
+	}
+	switch cmd {
+	case "status":
+		return status(s.r.Status()), nil
+	case "pause":
+		s.r.Pause()
+	case "resume":
+		s.r.Resume()
+	case "step":
+		s.r.Step()
+	case "skip":
+		n, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return "", err
+		}
+		if n < 0 {
+			return "", errors.New("negative skip")
+		}
+		s.r.Skip(n)
+	case "interval":
+		d, err := time.ParseDuration(args[0])
+		if err != nil {
+			return "", err
+		}
+		s.r.SetWaitDuration(d)
+	case "rate":
+		return "", s.setRate(args)
+	case "drain":
+		s.r.Drain()
+	}
+	return "", nil
+}
+
+func (s *Server) setRate(args []string) error {
+	if s.rl == nil {
+		return ErrNoRateLimit
+	}
+	var opts ratelimit.Options
+	var err error
+	if opts.Rate, err = strconv.ParseFloat(args[0], 64); err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		if opts.ByteRate, err = strconv.ParseFloat(args[1], 64); err != nil {
+			return err
+		}
+	}
+	if len(args) > 2 {
+		if opts.Burst, err = strconv.Atoi(args[2]); err != nil {
+			return err
+		}
+	}
+	if len(args) > 3 {
+		if opts.ByteBurst, err = strconv.Atoi(args[3]); err != nil {
+			return err
+		}
+	}
+	s.rl.SetLimits(opts)
+	return nil
+}
+
+// status formats a runner.Status as space-separated key=value pairs.
+func status(st runner.Status) string {
+	s := fmt.Sprintf("chunks=%v offset=%v skipped=%v paused=%v draining=%v interval=%v",
+		st.Chunks, st.Offset, st.Skipped, st.Paused, st.Draining, st.WaitDuration)
+	if st.Input != "" {
+		s += fmt.Sprintf(" input=%q inputs=%v/%v", st.Input, st.InputNum, st.Inputs)
+	}
+	return s
+}
+
+// Send sends a single request line to the control socket at path, returns the response line.
+// Returns an error if the response is an error.
+func Send(path, line string) (string, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, line); err != nil {
+		return "", err
+	}
+	sc := bufio.NewScanner(conn)
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return "", err
+		}
+		return "", errors.New("no response")
+	}
+	resp := sc.Text()
+	if strings.HasPrefix(resp, "error") {
+		return "", errors.New(strings.TrimSpace(strings.TrimPrefix(resp, "error")))
+	}
+	return strings.TrimSpace(strings.TrimPrefix(resp, "ok")), nil
+}
@@ -0,0 +1,124 @@
+package control
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/runner"
+	"github.com/hazaelsan/pipe-throttler/throttler/ratelimit"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+type fakeRunner struct {
+	calls []string
+	st    runner.Status
+}
+
+func (f *fakeRunner) Pause()  { f.calls = append(f.calls, "pause") }
+func (f *fakeRunner) Resume() { f.calls = append(f.calls, "resume") }
+func (f *fakeRunner) Step()   { f.calls = append(f.calls, "step") }
+func (f *fakeRunner) Drain()  { f.calls = append(f.calls, "drain") }
+
+func (f *fakeRunner) Skip(n int64) {
+	f.st.Skipped += n
+	f.calls = append(f.calls, "skip")
+}
+
+func (f *fakeRunner) SetWaitDuration(d time.Duration) {
+	f.st.WaitDuration = d
+	f.calls = append(f.calls, "interval")
+}
+
+func (f *fakeRunner) Status() runner.Status {
+	return f.st
+}
+
+type fakeRateLimiter struct {
+	opts ratelimit.Options
+}
+
+func (f *fakeRateLimiter) SetLimits(opts ratelimit.Options) {
+	f.opts = opts
+}
+
+func TestHandle(t *testing.T) {
+	testdata := []struct {
+		line string
+		want string
+	}{
+		{"", "error empty command"},
+		{"bogus", `error unknown command "bogus"`},
+		{"pause", "ok"},
+		{"resume", "ok"},
+		{"step", "ok"},
+		{"  skip   3 ", "ok"},
+		{"skip", "error wrong number of arguments for skip"},
+		{"skip -1", "error negative skip"},
+		{"skip x", `error strconv.ParseInt: parsing "x": invalid syntax`},
+		{"interval 250ms", "ok"},
+		{"interval x", `error time: invalid duration "x"`},
+		{"rate 10 2048 20 4096", "ok"},
+		{"rate 10 2048 20 4096 1", "error wrong number of arguments for rate"},
+		{"rate x", `error strconv.ParseFloat: parsing "x": invalid syntax`},
+		{"status", "ok chunks=0 offset=0 skipped=3 paused=false draining=false interval=250ms"},
+		{"drain", "ok"},
+	}
+	r := new(fakeRunner)
+	rl := new(fakeRateLimiter)
+	s := New(r, rl)
+	for _, tt := range testdata {
+		if got := s.Handle(tt.line); got != tt.want {
+			t.Errorf("Handle(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+	if diff := pretty.Compare(r.calls, []string{"pause", "resume", "step", "skip", "interval", "drain"}); diff != "" {
+		t.Errorf("calls -got +want:\n%v", diff)
+	}
+	if diff := pretty.Compare(rl.opts, ratelimit.Options{Rate: 10, ByteRate: 2048, Burst: 20, ByteBurst: 4096}); diff != "" {
+		t.Errorf("SetLimits() -got +want:\n%v", diff)
+	}
+	if got, want := New(r, nil).Handle("rate 1"), "error "+ErrNoRateLimit.Error(); got != want {
+		t.Errorf("Handle(rate) = %q, want %q", got, want)
+	}
+}
+
+func TestHandle_statusInput(t *testing.T) {
+	r := &fakeRunner{st: runner.Status{Chunks: 5, Input: "logs/b.log", InputNum: 2, Inputs: 3}}
+	want := `ok chunks=5 offset=0 skipped=0 paused=false draining=false interval=0s input="logs/b.log" inputs=2/3`
+	if got := New(r, nil).Handle("status"); got != want {
+		t.Errorf("Handle(status) = %q, want %q", got, want)
+	}
+}
+
+func TestSend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pt.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go New(&fakeRunner{st: runner.Status{Chunks: 5}}, nil).Serve(l)
+
+	got, err := Send(path, "status")
+	if err != nil {
+		t.Errorf("Send(status) error = %v", err)
+	}
+	if want := "chunks=5 offset=0 skipped=0 paused=false draining=false interval=0s"; got != want {
+		t.Errorf("Send(status) = %q, want %q", got, want)
+	}
+	if _, err := Send(path, "bogus"); err == nil {
+		t.Error("Send(bogus) error = nil")
+	}
+	if _, err := Send(filepath.Join(dir, "missing.sock"), "status"); err == nil {
+		t.Error("Send(missing) error = nil")
+	}
+}
@@ -0,0 +1,275 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+)
+
+// Compression is an input compression format.
+type Compression int
+
+const (
+	// Auto detects gzip, bzip2 and zlib by their headers, falling back to None.
+	// zlib's 2-byte header is easily mistaken for text, the start of the stream must also decompress without errors.
+	Auto Compression = iota
+
+	// None reads input as is.
+	None
+
+	// Gzip decompresses gzip input, including concatenated gzip members.
+	Gzip
+
+	// Bzip2 decompresses bzip2 input, including concatenated bzip2 streams.
+	Bzip2
+
+	// Zlib decompresses zlib input.
+	Zlib
+)
+
+const (
+	// magicLen is how many bytes are needed to detect a compression format from its header.
+	magicLen = 10
+
+	// zlibPeekLen is how much of a zlib stream is decompressed to tell it apart from text.
+	zlibPeekLen = 512
+
+	// detectLen is how many bytes are needed to detect any compression format.
+	detectLen = zlibPeekLen
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZ")
+
+	// zlibCMF is a zlib stream's first byte for deflate with a 32 KiB window, which is what zlib writers use.
+	zlibCMF byte = 0x78
+
+	// bzip2Block and bzip2End are the magic numbers at the start of a bzip2 block and of the end of the stream.
+	bzip2Block = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2End   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// ErrBadCompression is returned for an unknown compression format.
+var ErrBadCompression = errors.New("unknown compression format")
+
+var compressionNames = map[Compression]string{
+	Auto:  "auto",
+	None:  "none",
+	Gzip:  "gzip",
+	Bzip2: "bzip2",
+	Zlib:  "zlib",
+}
+
+// ParseCompression parses a compression format name.
+func ParseCompression(s string) (Compression, error) {
+	for c, name := range compressionNames {
+		if s == name {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("%q: %w", s, ErrBadCompression)
+}
+
+func (c Compression) String() string {
+	if name, ok := compressionNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+// Detect detects the compression format of data starting with b, None if it's not recognized.
+// The whole header is checked rather than just the magic bytes, so that text isn't mistaken for compressed data,
+// b needs to hold up to detectLen bytes, less only if that's all of the data.
+func Detect(b []byte) Compression {
+	switch {
+	case len(b) >= 4 && bytes.HasPrefix(b, gzipMagic) && b[2] == 8 && b[3]&0xe0 == 0:
+		// Deflate with no reserved flags set.
+		return Gzip
+	case len(b) >= 10 && bytes.HasPrefix(b, bzip2Magic) && b[2] == 'h' && b[3] >= '1' && b[3] <= '9' &&
+		(bytes.Equal(b[4:10], bzip2Block) || bytes.Equal(b[4:10], bzip2End)):
+		return Bzip2
+	case zlibHeader(b) && inflates(b):
+		return Zlib
+	}
+	return None
+}
+
+// zlibHeader returns whether b starts with a zlib header with a 32 KiB window, a valid checksum and no preset dictionary.
+func zlibHeader(b []byte) bool {
+	return len(b) >= 2 && b[0] == zlibCMF && (uint16(b[0])<<8|uint16(b[1]))%31 == 0 && b[1]&0x20 == 0
+}
+
+// inflates returns whether the start of a zlib stream decompresses without errors.
+// b is all of the data if it's shorter than zlibPeekLen, the stream must then be complete, checksum included.
+func inflates(b []byte) bool {
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return false
+	}
+	_, err = io.Copy(ioutil.Discard, zr)
+	return err == nil || err == io.ErrUnexpectedEOF && len(b) >= zlibPeekLen
+}
+
+// peekMagic returns the start of the data buffered by br, enough of it to detect its compression format.
+// Only the first 2 bytes are waited for unless they're a gzip or bzip2 magic number or a zlib header.
+func peekMagic(br *bufio.Reader) ([]byte, error) {
+	b, err := br.Peek(len(gzipMagic))
+	switch {
+	case err != nil:
+		return b, err
+	case bytes.Equal(b, gzipMagic) || bytes.Equal(b, bzip2Magic):
+		return br.Peek(magicLen)
+	case zlibHeader(b):
+		return br.Peek(zlibPeekLen)
+	}
+	return b, nil
+}
+
+// A countingReader counts how many bytes have been read, it's safe to call Count concurrently with Read.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+// Count returns how many bytes have been read.
+func (r *countingReader) Count() int64 {
+	return atomic.LoadInt64(&r.n)
+}
+
+// NewReader returns a Reader decompressing r, the compression format is detected if c is Auto.
+// Nothing is read from r until the Reader is first read or seeked.
+func NewReader(r io.Reader, c Compression) *Reader {
+	cr := &countingReader{r: r}
+	return &Reader{
+		c:   c,
+		src: r,
+		cr:  cr,
+		br:  bufio.NewReader(cr),
+	}
+}
+
+// A Reader decompresses its input, keeping track of how much of it has been read.
+type Reader struct {
+	c          Compression
+	src        io.Reader
+	cr         *countingReader
+	br         *bufio.Reader
+	r          io.Reader
+	err        error
+	compressed int32
+}
+
+// init detects the compression format if needed, and sets up decompression.
+func (r *Reader) init() error {
+	if r.r != nil || r.err != nil {
+		return r.err
+	}
+	if r.c == Auto {
+		b, err := peekMagic(r.br)
+		if err != nil && err != io.EOF {
+			r.err = err
+			return err
+		}
+		r.c = Detect(b)
+	}
+	switch r.c {
+	case None:
+		r.r = r.br
+	case Gzip:
+		r.r, r.err = gzip.NewReader(r.br)
+	case Bzip2:
+		r.r = bzip2.NewReader(r.br)
+	case Zlib:
+		r.r, r.err = zlib.NewReader(r.br)
+	default:
+		r.err = ErrBadCompression
+	}
+	if r.err != nil {
+		r.err = fmt.Errorf("%v: %w", r.c, r.err)
+		return r.err
+	}
+	if r.c != None {
+		atomic.StoreInt32(&r.compressed, 1)
+	}
+	return nil
+}
+
+// Read reads decompressed data.
+// Data is never returned along with io.EOF, which is only returned on the next call.
+func (r *Reader) Read(b []byte) (int, error) {
+	if err := r.init(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(b)
+	if n > 0 && err == io.EOF {
+		// Decompressors return their last data along with io.EOF, which split functions handle as a final token.
+		err = nil
+	}
+	return n, err
+}
+
+// Compression returns the compression format of the input, Auto if it hasn't been detected yet.
+// It must not be called concurrently with Read.
+func (r *Reader) Compression() Compression {
+	return r.c
+}
+
+// Position returns how much of the input has been read, including data that's been buffered but not decompressed yet.
+// Returns false if the input isn't compressed, in which case the amount of data read is a better measure.
+func (r *Reader) Position() (int64, bool) {
+	return r.cr.Count(), atomic.LoadInt32(&r.compressed) == 1
+}
+
+// Seek skips the first offset bytes of decompressed data, it must be called before reading any data.
+// Input that isn't compressed is seeked if possible, otherwise data is read and discarded.
+// Only io.SeekStart is supported.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return 0, ErrBadWhence
+	}
+	n, err := r.skip(offset)
+	if err == io.EOF {
+		err = fmt.Errorf("unable to skip %v more bytes: %w", offset-n, io.ErrUnexpectedEOF)
+	}
+	return n, err
+}
+
+// skip skips up to n bytes of decompressed data, returns io.EOF if there's less data than that.
+func (r *Reader) skip(n int64) (int64, error) {
+	if err := r.init(); err != nil {
+		return 0, err
+	}
+	if s, ok := r.src.(io.Seeker); ok && r.c == None {
+		// Seeking fails on pipes, which are then read.
+		if size, err := s.Seek(0, io.SeekEnd); err == nil {
+			skipped := n
+			if skipped > size {
+				skipped = size
+			}
+			if _, err := s.Seek(skipped, io.SeekStart); err != nil {
+				return 0, err
+			}
+			r.br.Reset(r.cr)
+			atomic.StoreInt64(&r.cr.n, skipped)
+			if skipped < n {
+				return skipped, io.EOF
+			}
+			return skipped, nil
+		}
+	}
+	return io.CopyN(ioutil.Discard, r, n)
+}
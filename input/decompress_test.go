@@ -0,0 +1,328 @@
+package input
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// bzip2Data is "foo\nbar\n" compressed with bzip2, the standard library can't compress it.
+const bzip2Data = "QlpoOTFBWSZTWav4YYsAAAJBgAAQMQCQACAAMMAIYaUs6BhdyRThQkKv4YYs"
+
+func gzipData(t *testing.T, members ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, m := range members {
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func zlibData(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseCompression(t *testing.T) {
+	for _, c := range []Compression{Auto, None, Gzip, Bzip2, Zlib} {
+		got, err := ParseCompression(c.String())
+		if err != nil {
+			t.Errorf("ParseCompression(%v) error = %v", c, err)
+		}
+		if got != c {
+			t.Errorf("ParseCompression(%v) = %v", c, got)
+		}
+	}
+	if _, err := ParseCompression("xz"); !errors.Is(err, ErrBadCompression) {
+		t.Errorf("ParseCompression(xz) error = %v, want %v", err, ErrBadCompression)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	bz, err := base64.StdEncoding.DecodeString(bzip2Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Incompressible data makes for a long stream, of which only the start is peeked at.
+	long := make([]byte, 4*zlibPeekLen)
+	rand.New(rand.NewSource(1)).Read(long)
+	text := "x^2 + y^2 = z^2\n"
+	testdata := []struct {
+		name string
+		b    []byte
+		want Compression
+	}{
+		{"gzip", gzipData(t, "foo"), Gzip},
+		{"bzip2", bz, Bzip2},
+		{"zlib", zlibData(t, "foo"), Zlib},
+		{"zlib start", zlibData(t, string(long))[:zlibPeekLen], Zlib},
+		{"truncated zlib", zlibData(t, "foo")[:4], None},
+		{"text", []byte("foo\n"), None},
+		{"zlib-like text", []byte("x^2 + y^2\n"), None},
+		{"zlib-like short text", []byte("x^y\n"), None},
+		{"zlib-like long text", []byte(strings.Repeat(text, zlibPeekLen/len(text)+1)[:zlibPeekLen]), None},
+		{"zlib-like binary", append([]byte{0x78, 0x01}, long[:zlibPeekLen-2]...), None},
+		{"bzip2-like text", []byte("BZh is a thing\n"), None},
+		{"bzip2-like level", []byte("BZh9 things\n"), None},
+		{"short", []byte{0x1f}, None},
+		{"empty", nil, None},
+	}
+	for _, tt := range testdata {
+		if got := Detect(tt.b); got != tt.want {
+			t.Errorf("Detect(%v) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	bz, err := base64.StdEncoding.DecodeString(bzip2Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testdata := []struct {
+		name  string
+		b     []byte
+		c     Compression
+		skip  int64
+		want  string
+		wantC Compression
+		err   error
+	}{
+		{
+			name:  "auto gzip",
+			b:     gzipData(t, "foo\nbar\n"),
+			want:  "foo\nbar\n",
+			wantC: Gzip,
+		},
+		{
+			name:  "concatenated gzip",
+			b:     gzipData(t, "foo\n", "bar\n"),
+			want:  "foo\nbar\n",
+			wantC: Gzip,
+		},
+		{
+			name:  "auto bzip2",
+			b:     bz,
+			want:  "foo\nbar\n",
+			wantC: Bzip2,
+		},
+		{
+			name:  "auto zlib",
+			b:     zlibData(t, "foo\nbar\n"),
+			want:  "foo\nbar\n",
+			wantC: Zlib,
+		},
+		{
+			name:  "zlib",
+			b:     zlibData(t, "foo\nbar\n"),
+			c:     Zlib,
+			want:  "foo\nbar\n",
+			wantC: Zlib,
+		},
+		{
+			name:  "auto none",
+			b:     []byte("foo\nbar\n"),
+			want:  "foo\nbar\n",
+			wantC: None,
+		},
+		{
+			name:  "auto compressed-like text",
+			b:     []byte("BZh is a thing\nx^2 + y^2\n"),
+			want:  "BZh is a thing\nx^2 + y^2\n",
+			wantC: None,
+		},
+		{
+			name:  "auto zlib-like text",
+			b:     []byte("x^2 + y^2\nBZh is a thing\n"),
+			want:  "x^2 + y^2\nBZh is a thing\n",
+			wantC: None,
+		},
+		{
+			name:  "none",
+			b:     gzipData(t, "foo"),
+			c:     None,
+			want:  string(gzipData(t, "foo")),
+			wantC: None,
+		},
+		{
+			name:  "skip",
+			b:     gzipData(t, "foo\n", "bar\n"),
+			skip:  5,
+			want:  "ar\n",
+			wantC: Gzip,
+		},
+		{
+			name:  "skip too much",
+			b:     gzipData(t, "foo\n"),
+			skip:  5,
+			wantC: Gzip,
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name: "bad gzip",
+			b:    []byte("foo bar baz"),
+			c:    Gzip,
+			err:  gzip.ErrHeader,
+		},
+		{
+			name: "bad compression",
+			c:    Compression(-1),
+			err:  ErrBadCompression,
+		},
+	}
+	for _, tt := range testdata {
+		r := NewReader(bytes.NewReader(tt.b), tt.c)
+		var err error
+		if tt.skip > 0 {
+			_, err = r.Seek(tt.skip, io.SeekStart)
+		}
+		b, rErr := ioutil.ReadAll(r)
+		if err == nil {
+			err = rErr
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Read(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if err != nil {
+			continue
+		}
+		if got := r.Compression(); got != tt.wantC {
+			t.Errorf("Compression(%v) = %v, want %v", tt.name, got, tt.wantC)
+		}
+		if got := string(b); got != tt.want {
+			t.Errorf("Read(%v) = %q, want %q", tt.name, got, tt.want)
+		}
+		if pos, ok := r.Position(); pos != int64(len(tt.b)) || ok != (tt.wantC != None) {
+			t.Errorf("Position(%v) = %v, %v, want %v, %v", tt.name, pos, ok, len(tt.b), tt.wantC != None)
+		}
+	}
+}
+
+func TestReader_seekPipe(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	go func() {
+		pw.Write([]byte("foo\nbar\n"))
+		pw.Close()
+	}()
+	r := NewReader(pr, Auto)
+	if _, err := r.Seek(4, io.SeekStart); err != nil {
+		t.Errorf("Seek() error = %v", err)
+	}
+	if b, err := ioutil.ReadAll(r); string(b) != "bar\n" || err != nil {
+		t.Errorf("Read() = %q, %v, want %q", b, err, "bar\n")
+	}
+}
+
+func TestFiles_decompress(t *testing.T) {
+	dir := tempFiles(t, map[string]string{
+		"1.gz":  string(gzipData(t, "foo\n", "bar\n")),
+		"2":     "baz bar baz\n",
+		"3.zz":  string(zlibData(t, "quux\n")),
+		"4.gz":  string(gzipData(t, "blah\n")),
+		"5.txt": "last\n",
+	})
+	defer os.RemoveAll(dir)
+	var paths []string
+	var size int64
+	for _, name := range []string{"1.gz", "2", "3.zz", "4.gz", "5.txt"} {
+		path := filepath.Join(dir, name)
+		paths = append(paths, path)
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += fi.Size()
+	}
+	testdata := []struct {
+		name   string
+		paths  []string
+		c      Compression
+		follow bool
+		skip   int64
+		want   []string
+		err    error
+	}{
+		{
+			name:  "auto",
+			paths: paths,
+			want:  []string{"foo\nbar\n", "baz bar baz\n", "quux\n", "blah\n", "last\n"},
+		},
+		{
+			name:  "skip",
+			paths: paths,
+			skip:  22,
+			want:  []string{"ux\n", "blah\n", "last\n"},
+		},
+		{
+			name:  "skip everything",
+			paths: paths,
+			skip:  35,
+		},
+		{
+			name:  "explicit",
+			paths: paths[:1],
+			c:     Gzip,
+			want:  []string{"foo\nbar\n"},
+		},
+		{
+			name:  "wrong format",
+			paths: paths[1:2],
+			c:     Gzip,
+			err:   gzip.ErrHeader,
+		},
+		{
+			name:   "follow compressed",
+			paths:  paths[:1],
+			follow: true,
+			err:    ErrFollowCompressed,
+		},
+	}
+	for _, tt := range testdata {
+		f := Open(tt.paths, Options{Decompress: tt.c, Follow: tt.follow})
+		if _, err := f.Seek(tt.skip, io.SeekStart); err != nil {
+			t.Errorf("Seek(%v) error = %v", tt.name, err)
+		}
+		got, err := readAll(f)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Next(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("Next(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+		if tt.err != nil || len(tt.paths) != len(paths) {
+			continue
+		}
+		if pos, ok := f.Position(); pos != size || !ok {
+			t.Errorf("Position(%v) = %v, %v, want %v, true", tt.name, pos, ok, size)
+		}
+	}
+}
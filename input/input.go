@@ -0,0 +1,341 @@
+// Package input reads a sequence of input files, one after another, decompressing them as needed.
+// Each file is read in full before moving on to the next, so that chunks of data never span files.
+package input
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultPoll is how often a followed file is checked for new data by default.
+const DefaultPoll = time.Second
+
+var (
+	// ErrNoMatch is returned when an input pattern doesn't match any files.
+	ErrNoMatch = errors.New("no matching files")
+
+	// ErrBadWhence is returned when seeking relative to anything but the start of the input.
+	ErrBadWhence = errors.New("can only seek relative to the start")
+
+	// ErrFollowCompressed is returned when following a compressed file.
+	ErrFollowCompressed = errors.New("unable to follow a compressed file")
+)
+
+// Expand expands input patterns into a list of files.
+// A pattern is either a file, a glob or a directory, a directory expands to the regular files directly in it.
+// Globs and directories are expanded in lexical order, patterns are expanded in the order given.
+func Expand(patterns []string) ([]string, error) {
+	var paths []string
+	for _, pat := range patterns {
+		matches, err := filepath.Glob(pat)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%v: %w", pat, ErrNoMatch)
+		}
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil {
+				return nil, err
+			}
+			if !fi.IsDir() {
+				paths = append(paths, m)
+				continue
+			}
+			fis, err := ioutil.ReadDir(m)
+			if err != nil {
+				return nil, err
+			}
+			for _, fi := range fis {
+				if fi.Mode().IsRegular() {
+					paths = append(paths, filepath.Join(m, fi.Name()))
+				}
+			}
+		}
+	}
+	return paths, nil
+}
+
+// Options is a set of options to open a sequence of input files.
+type Options struct {
+	// Follow indicates whether the last file should be followed as it grows, like tail -F.
+	// A followed file is reopened if it's rotated, and read again from the start if it's truncated.
+	// A followed file can't be compressed.
+	Follow bool
+
+	// Poll is how often a followed file is checked for new data, DefaultPoll if <= 0.
+	Poll time.Duration
+
+	// Decompress is the compression format of the files, detected separately for each file if Auto.
+	Decompress Compression
+
+	// OnOpen, if set, is called each time a file is opened with its path and index in the sequence, e.g., to report progress.
+	// Files skipped in full when seeking aren't opened.
+	OnOpen func(path string, index int)
+}
+
+// Open prepares a sequence of input files, files are only opened as they're needed.
+func Open(paths []string, opts Options) *Files {
+	if opts.Poll <= 0 {
+		opts.Poll = DefaultPoll
+	}
+	return &Files{
+		paths: paths,
+		opts:  opts,
+		sleep: time.Sleep,
+	}
+}
+
+// Files is a sequence of input files.
+type Files struct {
+	paths []string
+	opts  Options
+	next  int
+	cur   io.Closer
+	skip  int64
+	sleep func(time.Duration)
+
+	// mu guards the fields used by Position.
+	mu         sync.Mutex
+	cr         *countingReader
+	done       int64
+	compressed bool
+}
+
+// Size returns the combined size of all input files, 0 if they're followed or any of them isn't a regular file.
+// The size of compressed files is their compressed size.
+func (f *Files) Size() int64 {
+	if f.opts.Follow {
+		return 0
+	}
+	var size int64
+	for _, path := range f.paths {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0
+		}
+		size += fi.Size()
+	}
+	return size
+}
+
+// Position returns how much of the input files has been read, see Reader.Position.
+// Returns false if none of the files opened so far are compressed.
+func (f *Files) Position() (int64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pos := f.done
+	if f.cr != nil {
+		pos += f.cr.Count()
+	}
+	return pos, f.compressed
+}
+
+// Seek skips the first offset bytes of the combined input files, it must be called before reading any of them.
+// The offset of compressed files is counted in decompressed bytes, which are read and discarded.
+// Only io.SeekStart is supported.
+func (f *Files) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		return 0, ErrBadWhence
+	}
+	f.skip = offset
+	return offset, nil
+}
+
+// Next opens the next input file, closing the previous one.
+// Returns io.EOF if there are no input files left.
+func (f *Files) Next() (io.Reader, error) {
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	for f.next < len(f.paths) {
+		path := f.paths[f.next]
+		f.next++
+		fh, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r, err := f.open(fh, f.opts.Follow && f.next == len(f.paths))
+		if err != nil {
+			fh.Close()
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		if r == nil {
+			// Skipped in full.
+			fh.Close()
+			continue
+		}
+		if f.opts.OnOpen != nil {
+			f.opts.OnOpen(path, f.next-1)
+		}
+		return r, nil
+	}
+	if f.skip > 0 {
+		return nil, fmt.Errorf("unable to skip %v more bytes: %w", f.skip, io.ErrUnexpectedEOF)
+	}
+	return nil, io.EOF
+}
+
+// open prepares an input file for reading, skipping data as needed.
+// Returns nil if the file is skipped in full.
+func (f *Files) open(fh *os.File, follow bool) (io.Reader, error) {
+	c := f.opts.Decompress
+	if c == Auto {
+		b := make([]byte, detectLen)
+		n, err := fh.ReadAt(b, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		c = Detect(b[:n])
+	}
+	if c != None {
+		return f.openCompressed(fh, c, follow)
+	}
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if f.skip > 0 {
+		// Files consumed in full are skipped, unless it's the followed file.
+		if f.skip >= fi.Size() && !follow {
+			f.skip -= fi.Size()
+			f.consumed(fi.Size(), false)
+			return nil, nil
+		}
+		if _, err := fh.Seek(f.skip, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	cr := &countingReader{r: fh, n: f.skip}
+	f.skip = 0
+	f.setCurrent(cr, false)
+	if follow {
+		r := &follower{f: fh, path: fh.Name(), poll: f.opts.Poll, sleep: f.sleep}
+		cr.r = r
+		f.cur = r
+		return cr, nil
+	}
+	f.cur = fh
+	return cr, nil
+}
+
+// openCompressed prepares a compressed input file for reading, skipping data as needed.
+// Returns nil if the file is skipped in full.
+func (f *Files) openCompressed(fh *os.File, c Compression, follow bool) (io.Reader, error) {
+	if follow {
+		return nil, ErrFollowCompressed
+	}
+	r := NewReader(fh, c)
+	if err := r.init(); err != nil {
+		return nil, err
+	}
+	if f.skip > 0 {
+		n, err := r.skip(f.skip)
+		f.skip -= n
+		if err == io.EOF {
+			f.consumed(r.cr.Count(), true)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	f.setCurrent(r.cr, true)
+	f.cur = fh
+	return r, nil
+}
+
+// consumed records a file skipped in full.
+func (f *Files) consumed(n int64, compressed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.done += n
+	f.compressed = f.compressed || compressed
+}
+
+// setCurrent records the current file's read count.
+func (f *Files) setCurrent(cr *countingReader, compressed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cr = cr
+	f.compressed = f.compressed || compressed
+}
+
+// Close closes the current input file, if any.
+func (f *Files) Close() error {
+	if f.cur == nil {
+		return nil
+	}
+	err := f.cur.Close()
+	f.cur = nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.done += f.cr.Count()
+	f.cr = nil
+	return err
+}
+
+// A follower reads a file as it grows, like tail -F.
+type follower struct {
+	f     *os.File
+	path  string
+	poll  time.Duration
+	sleep func(time.Duration)
+}
+
+// Read reads from the file, waiting for more data once the end of the file is reached.
+// The file is reopened if it's rotated once the old file has been read in full,
+// and read again from the start if it's truncated.
+func (r *follower) Read(b []byte) (int, error) {
+	for {
+		n, err := r.f.Read(b)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+		if err := r.check(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// check checks whether the file has been rotated or truncated, otherwise it waits for more data.
+func (r *follower) check() error {
+	cur, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(r.path)
+	switch {
+	case err == nil && !os.SameFile(cur, fi):
+		f, err := os.Open(r.path)
+		if err != nil {
+			return err
+		}
+		r.f.Close()
+		r.f = f
+		return nil
+	case err != nil && !os.IsNotExist(err):
+		return err
+	}
+	off, err := r.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if cur.Size() < off {
+		_, err := r.f.Seek(0, io.SeekStart)
+		return err
+	}
+	r.sleep(r.poll)
+	return nil
+}
+
+func (r *follower) Close() error {
+	return r.f.Close()
+}
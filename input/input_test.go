@@ -0,0 +1,282 @@
+package input
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// tempFiles creates a temporary directory with the given files,
+// returns the directory, which must be removed once done.
+func tempFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// readAll reads all input files, each file's data is returned separately.
+func readAll(f *Files) ([]string, error) {
+	var got []string
+	for {
+		r, err := f.Next()
+		if err == io.EOF {
+			return got, nil
+		}
+		if err != nil {
+			return got, err
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return got, err
+		}
+		got = append(got, string(b))
+	}
+}
+
+func TestExpand(t *testing.T) {
+	dir := tempFiles(t, map[string]string{
+		"a.log":     "a",
+		"b.log":     "b",
+		"c.txt":     "c",
+		"d/2.log":   "d2",
+		"d/1.log":   "d1",
+		"d/e/3.log": "e3",
+	})
+	defer os.RemoveAll(dir)
+	testdata := []struct {
+		name     string
+		patterns []string
+		want     []string
+		err      error
+	}{
+		{
+			name:     "file",
+			patterns: []string{"c.txt"},
+			want:     []string{"c.txt"},
+		},
+		{
+			name:     "glob",
+			patterns: []string{"*.log"},
+			want:     []string{"a.log", "b.log"},
+		},
+		{
+			name:     "directory",
+			patterns: []string{"d"},
+			want:     []string{"d/1.log", "d/2.log"},
+		},
+		{
+			name:     "pattern order",
+			patterns: []string{"c.txt", "d", "*.log"},
+			want:     []string{"c.txt", "d/1.log", "d/2.log", "a.log", "b.log"},
+		},
+		{
+			name:     "no match",
+			patterns: []string{"*.log", "*.gz"},
+			err:      ErrNoMatch,
+		},
+		{
+			name:     "bad pattern",
+			patterns: []string{"["},
+			err:      filepath.ErrBadPattern,
+		},
+	}
+	for _, tt := range testdata {
+		var patterns []string
+		for _, p := range tt.patterns {
+			patterns = append(patterns, filepath.Join(dir, p))
+		}
+		got, err := Expand(patterns)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Expand(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		var want []string
+		for _, p := range tt.want {
+			want = append(want, filepath.Join(dir, p))
+		}
+		if diff := pretty.Compare(got, want); diff != "" {
+			t.Errorf("Expand(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := tempFiles(t, map[string]string{
+		"1": "foo\n",
+		"2": "bar\nbaz\n",
+		"3": "",
+		"4": "quux",
+	})
+	defer os.RemoveAll(dir)
+	var paths []string
+	for _, name := range []string{"1", "2", "3", "4"} {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	testdata := []struct {
+		name   string
+		paths  []string
+		follow bool
+		skip   int64
+		want   []string
+		size   int64
+		err    error
+	}{
+		{
+			name:  "all",
+			paths: paths,
+			want:  []string{"foo\n", "bar\nbaz\n", "", "quux"},
+			size:  16,
+		},
+		{
+			name:  "skip whole file",
+			paths: paths,
+			skip:  4,
+			want:  []string{"bar\nbaz\n", "", "quux"},
+			size:  16,
+		},
+		{
+			name:  "skip partial file",
+			paths: paths,
+			skip:  8,
+			want:  []string{"baz\n", "", "quux"},
+			size:  16,
+		},
+		{
+			name:  "skip everything",
+			paths: paths,
+			skip:  16,
+			size:  16,
+		},
+		{
+			name:  "skip too much",
+			paths: paths,
+			skip:  17,
+			size:  16,
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "missing file",
+			paths: []string{paths[0], filepath.Join(dir, "nonexistent")},
+			want:  []string{"foo\n"},
+			size:  0,
+			err:   os.ErrNotExist,
+		},
+		{
+			name:   "follow has no size",
+			paths:  paths[:1],
+			follow: true,
+			size:   0,
+		},
+	}
+	for _, tt := range testdata {
+		var opened []string
+		onOpen := func(path string, i int) {
+			if path != tt.paths[i] {
+				t.Errorf("OnOpen(%v) = %v, %v, want %v", tt.name, path, i, tt.paths[i])
+			}
+			opened = append(opened, path)
+		}
+		f := Open(tt.paths, Options{Follow: tt.follow, OnOpen: onOpen})
+		if got := f.Size(); got != tt.size {
+			t.Errorf("Size(%v) = %v, want %v", tt.name, got, tt.size)
+		}
+		if tt.follow {
+			continue
+		}
+		if _, err := f.Seek(tt.skip, io.SeekStart); err != nil {
+			t.Errorf("Seek(%v) error = %v", tt.name, err)
+		}
+		got, err := readAll(f)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Next(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("Next(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+		if len(opened) != len(tt.want) {
+			t.Errorf("OnOpen(%v) = %v, want %v calls", tt.name, opened, len(tt.want))
+		}
+	}
+}
+
+func TestSeek_badWhence(t *testing.T) {
+	if _, err := Open(nil, Options{}).Seek(0, io.SeekCurrent); err != ErrBadWhence {
+		t.Errorf("Seek() error = %v, want %v", err, ErrBadWhence)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	dir := tempFiles(t, map[string]string{"log": "foo\n"})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	appendFile := func(s string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each step happens while the follower waits for more data.
+	steps := []func(){
+		func() { appendFile("bar\n") },
+		func() {
+			// Rotation, the old file is read in full before moving on.
+			appendFile("baz\n")
+			if err := os.Rename(path, path+".1"); err != nil {
+				t.Fatal(err)
+			}
+		},
+		func() {},
+		func() { appendFile("new\n") },
+		func() {
+			// Truncation.
+			if err := ioutil.WriteFile(path, []byte("x\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	f := Open([]string{path}, Options{Follow: true, Poll: time.Hour})
+	defer f.Close()
+	polls := 0
+	f.sleep = func(d time.Duration) {
+		if d != time.Hour {
+			t.Errorf("sleep(%v), want %v", d, time.Hour)
+		}
+		if polls < len(steps) {
+			steps[polls]()
+		}
+		polls++
+	}
+	r, err := f.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	want := "foo\nbar\nbaz\nnew\nx\n"
+	b := make([]byte, len(want))
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Errorf("Read() error = %v", err)
+	}
+	if got := string(b); got != want {
+		t.Errorf("Read() = %q, want %q", got, want)
+	}
+}
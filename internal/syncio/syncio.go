@@ -0,0 +1,24 @@
+// Package syncio implements I/O primitives that are safe for concurrent use.
+package syncio
+
+import (
+	"io"
+	"sync"
+)
+
+// NewWriter returns a Writer that serializes writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// A Writer serializes writes to an underlying writer.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *Writer) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(b)
+}
@@ -0,0 +1,26 @@
+package syncio
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	b := new(strings.Builder)
+	w := NewWriter(b)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				w.Write([]byte("foo\n"))
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := b.String(), strings.Repeat("foo\n", 1000); got != want {
+		t.Errorf("Write() = %d bytes, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,141 @@
+// Package metrics exports statistics in the Prometheus text exposition format,
+// either over HTTP or as a file for node_exporter's textfile collector.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/stats"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// A writer writes metrics, remembering the first error.
+type writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *writer) write(ss ...string) {
+	for _, s := range ss {
+		if w.err != nil {
+			return
+		}
+		_, w.err = w.w.WriteString(s)
+	}
+}
+
+func (w *writer) header(name, typ, help string) {
+	w.write("# HELP ", name, " ", help, "\n# TYPE ", name, " ", typ, "\n")
+}
+
+func (w *writer) metric(name, typ, help string, v float64) {
+	w.header(name, typ, help)
+	w.write(name, " ", formatFloat(v), "\n")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Write writes a snapshot's metrics to w.
+func Write(w io.Writer, s stats.Snapshot) error {
+	mw := &writer{w: bufio.NewWriter(w)}
+	mw.metric("pt_chunks_written_total", "counter", "Chunks of data written.", float64(s.Chunks))
+	mw.metric("pt_bytes_written_total", "counter", "Bytes of data written.", float64(s.Bytes))
+	mw.metric("pt_errors_total", "counter", "Errors encountered.", float64(s.Errors))
+	mw.metric("pt_chunks_rejected_total", "counter", "Chunks of data rejected by the wrapped command.", float64(s.Rejected))
+	mw.metric("pt_expect_timeouts_total", "counter", "Timeouts waiting for the wrapped command.", float64(s.Timeouts))
+	mw.metric("pt_command_restarts_total", "counter", "Restarts of the wrapped command.", float64(s.Restarts))
+	mw.metric("pt_sleep_seconds_total", "counter", "Time spent sleeping between chunks of data.", s.Sleep.Seconds())
+	mw.metric("pt_input_offset_bytes", "gauge", "Bytes of input consumed.", float64(s.Offset))
+	if s.Size > 0 {
+		mw.metric("pt_input_size_bytes", "gauge", "Size of the input.", float64(s.Size))
+	}
+
+	const wait = "pt_wait_seconds"
+	mw.header(wait, "histogram", "Time spent waiting for the throttler to be ready.")
+	var count int64
+	for i, n := range s.WaitHist {
+		count += n
+		le := "+Inf"
+		if i < len(stats.WaitBuckets) {
+			le = formatFloat(stats.WaitBuckets[i].Seconds())
+		}
+		mw.write(wait, `_bucket{le="`, le, `"} `, strconv.FormatInt(count, 10), "\n")
+	}
+	mw.write(wait, "_sum ", formatFloat(s.Wait.Seconds()), "\n")
+	mw.write(wait, "_count ", strconv.FormatInt(count, 10), "\n")
+	if mw.err != nil {
+		return mw.err
+	}
+	return mw.w.Flush()
+}
+
+// Handler returns an HTTP handler serving the current metrics.
+func Handler(s *stats.Stats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		Write(w, s.Snapshot())
+	})
+}
+
+// WriteFile atomically replaces the file at path with the current metrics.
+func WriteFile(path string, s *stats.Stats) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := Write(tmp, s.Snapshot()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// TempFile creates files only readable by their owner.
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteFileEvery writes the metrics file every interval until the returned function is called,
+// the metrics file is written one last time when stopping.
+// Errors are reported to errw.
+func WriteFileEvery(path string, s *stats.Stats, interval time.Duration, errw io.Writer) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	write := func() {
+		if err := WriteFile(path, s); err != nil {
+			io.WriteString(errw, "unable to write metrics: "+err.Error()+"\n")
+		}
+	}
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				write()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		write()
+	}
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/stats"
+)
+
+func newStats() *stats.Stats {
+	s := stats.New(100, 0)
+	s.AddChunk(10)
+	s.AddChunk(20)
+	s.AddError()
+	s.AddRejected()
+	s.AddTimeout()
+	s.AddRestart()
+	s.AddWait(3 * time.Millisecond)
+	s.AddWait(2 * time.Second)
+	s.AddSleep(1500 * time.Millisecond)
+	s.SetOffset(30)
+	return s
+}
+
+var wantLines = []string{
+	"# TYPE pt_chunks_written_total counter",
+	"pt_chunks_written_total 2",
+	"pt_bytes_written_total 30",
+	"pt_errors_total 1",
+	"pt_chunks_rejected_total 1",
+	"pt_expect_timeouts_total 1",
+	"pt_command_restarts_total 1",
+	"pt_sleep_seconds_total 1.5",
+	"pt_input_offset_bytes 30",
+	"pt_input_size_bytes 100",
+	"# TYPE pt_wait_seconds histogram",
+	`pt_wait_seconds_bucket{le="0.001"} 0`,
+	`pt_wait_seconds_bucket{le="0.005"} 1`,
+	`pt_wait_seconds_bucket{le="1"} 1`,
+	`pt_wait_seconds_bucket{le="2.5"} 2`,
+	`pt_wait_seconds_bucket{le="+Inf"} 2`,
+	"pt_wait_seconds_sum 2.003",
+	"pt_wait_seconds_count 2",
+}
+
+func checkLines(t *testing.T, name, got string) {
+	t.Helper()
+	lines := make(map[string]bool)
+	for _, l := range strings.Split(got, "\n") {
+		lines[l] = true
+	}
+	for _, l := range wantLines {
+		if !lines[l] {
+			t.Errorf("%v missing %q, got:\n%v", name, l, got)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var b strings.Builder
+	if err := Write(&b, newStats().Snapshot()); err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+	checkLines(t, "Write()", b.String())
+}
+
+func TestHandler(t *testing.T) {
+	srv := httptest.NewServer(Handler(newStats()))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Get() status = %v", resp.Status)
+	}
+	if got := resp.Header.Get("Content-Type"); got != ContentType {
+		t.Errorf("Get() Content-Type = %q, want %q", got, ContentType)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	checkLines(t, "Get()", string(b))
+}
+
+func TestWriteFileEvery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pt.prom")
+	stop := WriteFileEvery(path, newStats(), time.Hour, os.Stderr)
+	stop()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	checkLines(t, "WriteFileEvery()", string(b))
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("ReadDir() = %v entries, want 1", len(entries))
+	}
+	if err := WriteFile(filepath.Join(dir, "missing", "pt.prom"), newStats()); err == nil {
+		t.Error("WriteFile(missing dir) error = nil")
+	}
+}
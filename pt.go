@@ -3,69 +3,452 @@
 // Usage:
 //   $ some_producer | pt --interval=1s | some_consumer --consumer_args...
 //   $ some_producer | pt --interval=1s some_consumer -- --consumer_args...
+//   $ pt ctl --control_socket=/path/to/socket command [args...]
 //
 // See README.md for additional information between the two modes.
 package main
 
 import (
 	"bufio"
+	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/hazaelsan/pipe-throttler/checkpoint"
+	"github.com/hazaelsan/pipe-throttler/config"
+	"github.com/hazaelsan/pipe-throttler/control"
+	"github.com/hazaelsan/pipe-throttler/input"
+	"github.com/hazaelsan/pipe-throttler/metrics"
 	"github.com/hazaelsan/pipe-throttler/runner"
 	"github.com/hazaelsan/pipe-throttler/split"
+	"github.com/hazaelsan/pipe-throttler/stats"
 	"github.com/hazaelsan/pipe-throttler/throttler"
+	"github.com/hazaelsan/pipe-throttler/throttler/adaptive"
 	"github.com/hazaelsan/pipe-throttler/throttler/dummy"
+	"github.com/hazaelsan/pipe-throttler/throttler/execeach"
 	"github.com/hazaelsan/pipe-throttler/throttler/expect"
+	"github.com/hazaelsan/pipe-throttler/throttler/ratelimit"
 )
 
 var (
-	interval   = flag.Duration("interval", 0, "how long to wait after the throttler is ready before outputting the next data chunk")
-	size       = flag.Uint("size", 0, "how many bytes to read from stdin, overrides --split if > 0")
-	splitInput = flag.String("split", "\n", "regular expression on which to split stdin")
+	interval    = flag.Duration("interval", 0, "how long to wait after the throttler is ready before outputting the next data chunk")
+	size        = flag.Uint("size", 0, "how many bytes to read from stdin, overrides --split if > 0")
+	splitInput  = flag.String("split", "\n", "regular expression on which to split stdin")
+	recordStart = flag.String("record_start", "", "regular expression matching the first line of a multi-line record on stdin, overrides --split if set")
+	splitMode   = flag.String("split_mode", "regexp", "how to split stdin: regexp (per --size, --record_start or --split), json (one JSON value per data chunk), csv (one CSV record per data chunk), length (one length-prefixed binary frame per data chunk), uvarint (one uvarint-delimited binary frame, e.g., protobuf message, per data chunk), null (split on NUL bytes, e.g., from find -print0) or literal (split on --delimiter)")
+
+	sizeBoundary = flag.String("size_boundary", "byte", "where data chunks may end with --size or --expect_size: byte (anywhere), rune (between UTF-8 characters) or grapheme (between user-perceived characters, e.g., not before a combining accent); chunks only exceed the size if a single character does")
+
+	framePrefixSize      = flag.Uint("frame_prefix_size", 4, "size in bytes of the length prefix with --split_mode=length: 1, 2, 4 or 8")
+	frameByteOrder       = flag.String("frame_byte_order", "big", "byte order of the length prefix with --split_mode=length: big or little")
+	framePrefixInclusive = flag.Bool("frame_prefix_inclusive", false, "whether the length prefix counts itself with --split_mode=length, as opposed to just the payload")
+	maxFrameSize         = flag.Uint("max_frame_size", 0, "maximum frame size in bytes (including the length prefix) with --split_mode=length or uvarint, unlimited if 0")
+
+	csvDelimiter = flag.String("csv_delimiter", ",", "field delimiter with --split_mode=csv, a single byte")
+	csvQuote     = flag.String("csv_quote", `"`, "quote character with --split_mode=csv, a single byte")
+	header       = flag.String("header", "none", "what to do with the first data chunk of each input, e.g., a CSV header row: none (nothing special), chunk (prepend it to every other data chunk) or start (write it before the first data chunk to stdout or each wrapped command, including restarted ones)")
+
+	delimiterMode        = flag.String("delimiter_mode", "keep", "what to do with the delimiter at the end of each data chunk: keep, strip, replace (with --delimiter_replacement) or move (to the start of the next data chunk)")
+	delimiterReplacement = flag.String("delimiter_replacement", `\n`, "replacement for the delimiter with --delimiter_mode=replace, e.g., to normalize CRLF to LF; Go escape sequences are interpreted")
+
+	maxChunkSize    = flag.Uint("max_chunk_size", bufio.MaxScanTokenSize, "maximum size in bytes of a data chunk, or of a chunk of the wrapped command's output, delimiter included")
+	oversize        = flag.String("oversize", "error", "what to do with data chunks larger than --max_chunk_size: error (abort), truncate (to --max_chunk_size, the rest is discarded except for the delimiter), split (into data chunks of up to --max_chunk_size, the delimiter is kept with the last one) or skip (append them to --oversize_rejects, if set); only error is supported with --split_mode=json, csv, length or uvarint")
+	oversizeRejects = flag.String("oversize_rejects", "", "file to which to append the input making up data chunks skipped with --oversize=skip, as is")
+
+	rate      = flag.Float64("rate", 0, "maximum number of data chunks to output per second, unlimited if <= 0")
+	byteRate  = flag.Float64("byte_rate", 0, "maximum number of bytes to output per second, unlimited if <= 0")
+	burst     = flag.Int("burst", 0, "how many chunks can be output in a burst with --rate, defaults to one second's worth if <= 0")
+	byteBurst = flag.Int("byte_burst", 0, "how many bytes can be output in a burst with --byte_rate, defaults to one second's worth if <= 0")
+
+	targetLatency    = flag.Duration("target_latency", 0, "adaptively tune the output rate to keep the wrapped command's response time under this, disabled if <= 0")
+	adaptiveMinRate  = flag.Float64("adaptive_min_rate", adaptive.DefaultMinRate, "lowest number of data chunks per second to output with --target_latency, also the starting rate")
+	adaptiveMaxRate  = flag.Float64("adaptive_max_rate", 0, "highest number of data chunks per second to output with --target_latency, unlimited if <= 0")
+	adaptiveIncrease = flag.Float64("adaptive_increase", adaptive.DefaultIncrease, "how many data chunks per second to add to the rate each time the response time is under --target_latency")
+	adaptiveDecrease = flag.Float64("adaptive_decrease", adaptive.DefaultDecrease, "factor by which to multiply the rate each time the response time is over --target_latency")
 
 	expectSize    = flag.Uint("expect_size", 0, "how many bytes to read from the wrapped command, overrides --expect_split if > 0")
 	expectSplit   = flag.String("expect_split", "\n", "regular expression on which to split the wrapped command's output")
+	expectReady   = flag.String("expect_ready", "", "regular expression matching the wrapped command's prompt, other output is still split by --expect_split but doesn't count as a prompt")
+	expectError   = flag.String("expect_error", "", "regular expression matching the wrapped command's output when it rejects a data chunk")
+	expectSuccess = flag.String("expect_success", "", "regular expression matching the wrapped command's output when it accepts a data chunk, data chunks are rejected if there's no match")
 	expectStderr  = flag.Bool("expect_stderr", false, "whether to match the wrapped command's stderr as opposed to stdout")
+	expectScript  = flag.String("expect_script", "", "file with a sequence of send/expect steps to run for each data chunk, overrides --expect_size and --expect_split")
 	expectTimeout = flag.Duration("expect_timeout", 0, "how long to wait for the wrapped command to match --expect_split, waits forever if <= 0")
+	expectPTY     = flag.Bool("expect_pty", false, "whether to run the wrapped command in a pseudo-terminal, both its stdout and stderr are matched")
+	expectPTYEcho = flag.Bool("expect_pty_echo", false, "whether the pseudo-terminal echoes input back to the wrapped command's output")
+	expectPTYRows = flag.Uint("expect_pty_rows", 0, "pseudo-terminal window height, taken from the current terminal if unset")
+	expectPTYCols = flag.Uint("expect_pty_cols", 0, "pseudo-terminal window width, taken from the current terminal if unset")
+
+	expectDelimiterMode        = flag.String("expect_delimiter_mode", "keep", "what to do with the delimiter at the end of each chunk of the wrapped command's output, e.g., before matching it or writing it to stdout: keep, strip, replace (with --expect_delimiter_replacement) or move (to the start of the next chunk)")
+	expectDelimiterReplacement = flag.String("expect_delimiter_replacement", `\n`, "replacement for the delimiter with --expect_delimiter_mode=replace; Go escape sequences are interpreted")
+
+	expectOversize = flag.String("expect_oversize", "error", "what to do with chunks of the wrapped command's output larger than --max_chunk_size: error (abort), truncate, split or skip (neither matched nor written to stdout)")
+
+	onTimeout      = flag.String("on_timeout", "abort", "what to do when --expect_timeout is exceeded: abort, skip (the current data chunk), retry (re-send it) or restart (the wrapped command and re-send it)")
+	timeoutRetries = flag.Uint("timeout_retries", 3, "how many times to retry or restart with --on_timeout before aborting")
+	timeoutBackoff = flag.Duration("timeout_backoff", time.Second, "how long to wait before the first retry or restart with --on_timeout, doubled on each subsequent attempt")
+
+	restart        = flag.String("restart", "no", "whether to respawn the wrapped command if it exits before responding to all data chunks, the last one included: no, on-failure or always")
+	maxRestarts    = flag.Uint("max_restarts", 10, "how many times to respawn the wrapped command with --restart, unlimited if 0")
+	restartBackoff = flag.Duration("restart_backoff", time.Second, "how long to wait before respawning the wrapped command with --restart")
+
+	workers = flag.Uint("workers", 1, "how many copies of the wrapped command to run, each data chunk is output to whichever is ready first")
+	ordered = flag.Bool("ordered", false, "whether to output the wrapped commands' responses in input order if --workers > 1")
+
+	execEach = flag.Bool("exec_each", false, "whether to run the wrapped command once per data chunk (up to --workers at a time), passing the data chunk on stdin or in place of {} in its arguments")
+
+	deadLetter = flag.String("dead_letter", "", "file to which to append data chunks rejected by the wrapped command as JSON lines, rejected data chunks are fatal otherwise")
+
+	decompress = flag.String("decompress", "none", "compression format of stdin or --input files: none (read as is), auto (gzip, bzip2 or zlib detected by their headers, read as is otherwise), gzip, bzip2 or zlib")
+
+	follow         = flag.Bool("follow", false, "whether to follow the last --input file as it grows, like tail -F, it's reopened if rotated")
+	followInterval = flag.Duration("follow_interval", input.DefaultPoll, "how often to check the file for new data with --follow")
+
+	checkpointFile = flag.String("checkpoint", "", "file in which to record how much of stdin has been delivered")
+	resume         = flag.Bool("resume", false, "whether to resume from the offset recorded in --checkpoint, stdin (or --input) must be a regular file")
+
+	configFile    = flag.String("config", "", "settings file from which to reload --interval, --rate, --byte_rate, --burst and --byte_burst on SIGHUP")
+	controlSocket = flag.String("control_socket", "", "path of a Unix-domain socket on which to accept control commands, see `pt ctl`")
+
+	progress = flag.Duration("progress", 0, "how often to report progress to stderr, a final summary is also reported on exit if > 0")
+
+	metricsListen   = flag.String("metrics_listen", "", "address on which to serve Prometheus metrics over HTTP at /metrics, e.g., 127.0.0.1:9123")
+	metricsTextfile = flag.String("metrics_textfile", "", "file in which to write Prometheus metrics for node_exporter's textfile collector")
+	metricsInterval = flag.Duration("metrics_interval", 15*time.Second, "how often to write --metrics_textfile")
+
+	inputs     stringList
+	delimiters stringList
 )
 
-func newSplitFunc(size int, pat string) (bufio.SplitFunc, error) {
+func init() {
+	flag.Var(&inputs, "input", "file, glob or directory to read instead of stdin, can be repeated; files are read one after another")
+	flag.Var(&delimiters, "delimiter", "literal delimiter on which to split stdin with --split_mode=literal, can be repeated to split on any of them; Go escape sequences such as \\r\\n or \\x00 are interpreted")
+}
+
+// A stringList is a flag that can be set multiple times.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// newSplitFunc returns the split function for a split mode and a Delimiter for the tokens it returns,
+// nil if they have no delimiter; size, pat and recordStart are only used in regexp mode.
+func newSplitFunc(mode string, size int, pat, recordStart string) (bufio.SplitFunc, split.Delimiter, error) {
+	switch mode {
+	case "regexp":
+	case "json":
+		return split.ByJSON(), split.NewlineDelimiter, nil
+	case "csv":
+		f, err := csvSplitFunc(*csvDelimiter, *csvQuote)
+		return f, split.NewlineDelimiter, err
+	case "length":
+		f, err := lengthSplitFunc(int(*framePrefixSize), *frameByteOrder, *framePrefixInclusive, int(*maxFrameSize))
+		return f, nil, err
+	case "uvarint":
+		return split.ByUvarint(int(*maxFrameSize)), nil, nil
+	case "null":
+		return split.ByDelimiter([]byte{0}), split.LiteralDelimiter([]byte{0}), nil
+	case "literal":
+		return literalSplitFunc(delimiters)
+	default:
+		return nil, nil, fmt.Errorf("unknown split mode %q", mode)
+	}
 	if size > 0 {
-		return split.BySize(size), nil
+		f, err := sizeSplitFunc(size, *sizeBoundary)
+		return f, nil, err
+	}
+	if recordStart != "" {
+		re, err := regexp.Compile(recordStart)
+		if err != nil {
+			return nil, nil, err
+		}
+		return split.ByRecordStart(re), split.NewlineDelimiter, nil
 	}
 	if pat == "" {
-		return nil, errors.New("empty split pattern")
+		return nil, nil, errors.New("empty split pattern")
 	}
 	re, err := regexp.Compile(pat)
+	if err != nil {
+		return nil, nil, err
+	}
+	return split.ByRE(re), split.REDelimiter(re), nil
+}
+
+// delimiterOptions is what to do with the delimiter at the end of each data chunk, see split.WithDelimiter.
+type delimiterOptions struct {
+	// mode is a split.DelimiterMode name, keep if unset.
+	mode string
+
+	// term is the terminator in replace mode, Go escape sequences in it are interpreted.
+	term string
+}
+
+// wrap wraps a split function to handle the delimiter found by d as per o, f is returned unchanged in keep mode.
+func (o delimiterOptions) wrap(f bufio.SplitFunc, d split.Delimiter) (bufio.SplitFunc, error) {
+	if o.mode == "" {
+		return f, nil
+	}
+	m, err := split.ParseDelimiterMode(o.mode)
 	if err != nil {
 		return nil, err
 	}
-	return split.ByRE(re), nil
+	if m == split.Keep {
+		return f, nil
+	}
+	if d == nil {
+		return nil, fmt.Errorf("delimiter mode %v requires a split mode with delimiters", m)
+	}
+	term, err := unescape(o.term)
+	if err != nil {
+		return nil, fmt.Errorf("bad delimiter replacement %q: %w", o.term, err)
+	}
+	return split.WithDelimiter(f, d, m, []byte(term)), nil
 }
 
-func newThrottler(args []string, size int, split string, stderr bool, timeout time.Duration) (throttler.Throttler, error) {
+// delimiter returns the Delimiter for tokens split by a split function wrapped as per o, given the Delimiter d for the tokens
+// it split before, nil if they're left without a delimiter.
+func (o delimiterOptions) delimiter(d split.Delimiter) split.Delimiter {
+	switch o.mode {
+	case "strip", "move":
+		return nil
+	case "replace":
+		term, err := unescape(o.term)
+		if err != nil || term == "" || d == nil {
+			return nil
+		}
+		return split.LiteralDelimiter([]byte(term))
+	}
+	return d
+}
+
+// unescape interprets Go escape sequences in s, e.g., \r\n or \x00.
+func unescape(s string) (string, error) {
+	return strconv.Unquote(`"` + strings.ReplaceAll(s, `"`, `\"`) + `"`)
+}
+
+// sizeSplitFunc returns a split function for fixed-size chunks ending at a byte, rune or grapheme cluster boundary.
+func sizeSplitFunc(size int, boundary string) (bufio.SplitFunc, error) {
+	switch boundary {
+	case "byte":
+		return split.BySize(size), nil
+	case "rune":
+		return split.BySizeUTF8(size), nil
+	case "grapheme":
+		return split.BySizeGrapheme(size), nil
+	}
+	return nil, fmt.Errorf("unknown size boundary %q", boundary)
+}
+
+// csvSplitFunc returns a CSV split function for a delimiter and quote character.
+func csvSplitFunc(delim, quote string) (bufio.SplitFunc, error) {
+	if len(delim) != 1 || len(quote) != 1 || delim == quote || delim == "\n" || quote == "\n" {
+		return nil, fmt.Errorf("bad CSV delimiter %q or quote %q, must be distinct single bytes other than newline", delim, quote)
+	}
+	return split.ByCSVRecord(delim[0], quote[0]), nil
+}
+
+// literalSplitFunc returns a split function and a Delimiter for a set of literal delimiters,
+// Go escape sequences in them are interpreted.
+func literalSplitFunc(delims []string) (bufio.SplitFunc, split.Delimiter, error) {
+	if len(delims) == 0 {
+		return nil, nil, errors.New("--split_mode=literal requires --delimiter")
+	}
+	var bs [][]byte
+	for _, d := range delims {
+		s, err := unescape(d)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad delimiter %q: %w", d, err)
+		}
+		if s == "" {
+			return nil, nil, split.ErrEmptyDelimiter
+		}
+		bs = append(bs, []byte(s))
+	}
+	return split.ByDelimiter(bs...), split.LiteralDelimiter(bs...), nil
+}
+
+// lengthSplitFunc returns a split function for frames with a fixed-width length prefix.
+func lengthSplitFunc(size int, order string, inclusive bool, max int) (bufio.SplitFunc, error) {
+	if size != 1 && size != 2 && size != 4 && size != 8 {
+		return nil, split.ErrBadPrefixSize
+	}
+	p := split.LengthPrefix{Size: size, Inclusive: inclusive, Max: max}
+	switch order {
+	case "big":
+		p.Order = binary.BigEndian
+	case "little":
+		p.Order = binary.LittleEndian
+	default:
+		return nil, fmt.Errorf("unknown byte order %q", order)
+	}
+	return split.ByLengthPrefix(p), nil
+}
+
+// oversizePolicy parses an oversize policy for a split mode, policies other than error are only supported
+// by split modes that can find the end of a chunk of data from the middle of it.
+func oversizePolicy(policy, mode string, size, max int) (split.OversizePolicy, error) {
+	p, err := split.ParseOversizePolicy(policy)
+	if err != nil {
+		return 0, err
+	}
+	switch mode {
+	case "regexp":
+		if size > max {
+			return 0, fmt.Errorf("chunk size %d exceeds the maximum chunk size %d", size, max)
+		}
+		if size == max && *sizeBoundary != "byte" {
+			// The data following a full chunk may be needed to find where it ends.
+			return 0, fmt.Errorf("chunk size %d must be lower than the maximum chunk size %d with --size_boundary=%v", size, max, *sizeBoundary)
+		}
+		return p, nil
+	case "null", "literal":
+		return p, nil
+	}
+	if p != split.OversizeError {
+		return 0, fmt.Errorf("oversize policy %v is not supported with split mode %q", p, mode)
+	}
+	return p, nil
+}
+
+// expectOptions returns the expect throttler options set via flags.
+func expectOptions() (expect.Options, error) {
+	p, err := expect.ParsePolicy(*onTimeout)
+	if err != nil {
+		return expect.Options{}, err
+	}
+	rp, err := expect.ParseRestartPolicy(*restart)
+	if err != nil {
+		return expect.Options{}, err
+	}
+	var ready, failure, success *regexp.Regexp
+	if *expectReady != "" {
+		if ready, err = regexp.Compile(*expectReady); err != nil {
+			return expect.Options{}, err
+		}
+	}
+	if *expectError != "" {
+		if failure, err = regexp.Compile(*expectError); err != nil {
+			return expect.Options{}, err
+		}
+	}
+	if *expectSuccess != "" {
+		if success, err = regexp.Compile(*expectSuccess); err != nil {
+			return expect.Options{}, err
+		}
+	}
+	var script *expect.Script
+	if *expectScript != "" {
+		if script, err = expect.LoadScript(*expectScript); err != nil {
+			return expect.Options{}, err
+		}
+	}
+	op, err := oversizePolicy(*expectOversize, "regexp", int(*expectSize), int(*maxChunkSize))
+	if err != nil {
+		return expect.Options{}, err
+	}
+	return expect.Options{
+		Ready:          ready,
+		Error:          failure,
+		Success:        success,
+		Script:         script,
+		MaxChunkSize:   int(*maxChunkSize),
+		Oversize:       op,
+		MatchStderr:    *expectStderr,
+		Timeout:        *expectTimeout,
+		OnTimeout:      p,
+		Retries:        int(*timeoutRetries),
+		Backoff:        *timeoutBackoff,
+		Restart:        rp,
+		MaxRestarts:    int(*maxRestarts),
+		RestartBackoff: *restartBackoff,
+		UsePTY:         *expectPTY,
+		Echo:           *expectPTYEcho,
+		Rows:           uint16(*expectPTYRows),
+		Cols:           uint16(*expectPTYCols),
+	}, nil
+}
+
+// newThrottler instantiates an expect throttler for a wrapped command, or a dummy throttler if there's none.
+// The command and split function are set from args, size, pat and delim, other options are taken from opts.
+// A pool of wrapped commands is used if workers > 1.
+func newThrottler(args []string, size int, pat string, delim delimiterOptions, opts expect.Options, workers int, ordered bool) (throttler.Throttler, error) {
 	if len(args) == 0 {
+		if opts.Header != nil {
+			return dummy.NewWithHeader(os.Stdout, opts.Header), nil
+		}
 		return dummy.New(os.Stdout), nil
 	}
-	f, err := newSplitFunc(size, split)
+	f, d, err := newSplitFunc("regexp", size, pat, "")
 	if err != nil {
 		return nil, err
 	}
-	opts := expect.Options{
-		Command:     args,
-		MatchStderr: stderr,
-		SplitFunc:   f,
-		Timeout:     timeout,
+	if f, err = delim.wrap(f, d); err != nil {
+		return nil, err
+	}
+	opts.Command = args
+	opts.SplitFunc = f
+	opts.Delimiter = delim.delimiter(d)
+	if workers > 1 {
+		return expect.NewPool(opts, workers, ordered)
 	}
 	return expect.New(opts)
 }
 
+// newAdaptive wraps a throttler in an AIMD throttler if a target latency is set,
+// returns the throttler unchanged otherwise.
+func newAdaptive(t throttler.Throttler, opts adaptive.Options) (throttler.Throttler, error) {
+	if opts.Target <= 0 {
+		return t, nil
+	}
+	return adaptive.New(t, opts)
+}
+
+// newRateLimit wraps a throttler in a token bucket throttler if any rate limits are set, or if always is set.
+// Returns nil if the throttler doesn't need to be wrapped.
+func newRateLimit(t throttler.Throttler, opts ratelimit.Options, always bool) *ratelimit.RateLimit {
+	if opts.Rate <= 0 && opts.ByteRate <= 0 && !always {
+		return nil
+	}
+	return ratelimit.New(t, opts)
+}
+
+// inputSize returns the size of the input if it's a regular file, 0 otherwise.
+func inputSize(f *os.File) int64 {
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return 0
+	}
+	return fi.Size()
+}
+
+// resumeFrom seeks the input to the offset recorded in a checkpoint file.
+func resumeFrom(f io.Seeker, path string) (checkpoint.State, error) {
+	s, err := checkpoint.Load(path)
+	if err != nil {
+		return s, err
+	}
+	if _, err := f.Seek(s.Offset, io.SeekStart); err != nil {
+		return s, fmt.Errorf("unable to resume from offset %v: %w", s.Offset, err)
+	}
+	return s, nil
+}
+
 func exitCode(err error) int {
 	if err == nil {
 		return 0
@@ -74,37 +457,321 @@ func exitCode(err error) int {
 	if errors.As(err, &e) {
 		return e.ExitCode()
 	}
+	var f *execeach.FailedError
+	if errors.As(err, &f) {
+		// Same as xargs.
+		fmt.Fprintln(os.Stderr, err)
+		return 123
+	}
 	fmt.Fprintln(os.Stderr, err)
 	return 1
 }
 
-func newRunner() (*runner.Runner, error) {
-	f, err := newSplitFunc(int(*size), *splitInput)
+// A groupKiller is a throttler whose wrapped commands may run in their own process groups.
+type groupKiller interface {
+	KillGroup()
+}
+
+// A pipeline is a Runner along with its runtime-adjustable parts.
+type pipeline struct {
+	*runner.Runner
+	rl         *ratelimit.RateLimit
+	cmds       groupKiller
+	stats      *stats.Stats
+	deadLetter *os.File
+	rejects    *os.File
+	files      *input.Files
+
+	// settings are the runtime-adjustable settings given on the command line, which reloads start from.
+	settings config.Settings
+}
+
+// close closes the files opened for the pipeline.
+func (p *pipeline) close() {
+	if p.deadLetter != nil {
+		p.deadLetter.Close()
+	}
+	if p.rejects != nil {
+		p.rejects.Close()
+	}
+	if p.files != nil {
+		p.files.Close()
+	}
+}
+
+// rateLimits returns the rate limits in a set of settings.
+func rateLimits(s config.Settings) ratelimit.Options {
+	return ratelimit.Options{
+		Rate:      s.Rate,
+		ByteRate:  s.ByteRate,
+		Burst:     s.Burst,
+		ByteBurst: s.ByteBurst,
+	}
+}
+
+// reload reloads the runtime-adjustable settings from a settings file,
+// settings missing from the file are reset to their command-line values.
+func (p *pipeline) reload(path string) error {
+	s, err := config.Load(path, p.settings)
+	if err != nil {
+		return err
+	}
+	p.SetWaitDuration(s.Interval)
+	if p.rl != nil {
+		p.rl.SetLimits(rateLimits(s))
+	}
+	return nil
+}
+
+func newRunner() (_ *pipeline, err error) {
+	f, d, err := newSplitFunc(*splitMode, int(*size), *splitInput, *recordStart)
 	if err != nil {
 		return nil, err
 	}
-	t, err := newThrottler(flag.Args(), int(*expectSize), *expectSplit, *expectStderr, *expectTimeout)
+	chunkDelim := delimiterOptions{mode: *delimiterMode, term: *delimiterReplacement}
+	if f, err = chunkDelim.wrap(f, d); err != nil {
+		return nil, err
+	}
+	policy, err := oversizePolicy(*oversize, *splitMode, int(*size), int(*maxChunkSize))
 	if err != nil {
 		return nil, err
 	}
 	opts := runner.Options{
 		Reader:       os.Stdin,
-		Throttler:    t,
 		SplitFunc:    f,
+		MaxChunkSize: int(*maxChunkSize),
+		Oversize:     policy,
+		Delimiter:    chunkDelim.delimiter(d),
 		WaitDuration: *interval,
 	}
-	return runner.New(opts), nil
+	c, err := input.ParseCompression(*decompress)
+	if err != nil {
+		return nil, err
+	}
+	p := new(pipeline)
+	defer func() {
+		// Files opened along the way are closed if a later step fails.
+		if err != nil {
+			p.close()
+		}
+	}()
+	var in io.Seeker = os.Stdin
+	var position func() (int64, bool)
+	total := inputSize(os.Stdin)
+	switch {
+	case len(inputs) > 0:
+		paths, err := input.Expand(inputs)
+		if err != nil {
+			return nil, err
+		}
+		// Input files are only opened once the Runner is running.
+		onOpen := func(path string, i int) { p.SetInput(path, i+1, len(paths)) }
+		p.files = input.Open(paths, input.Options{Follow: *follow, Poll: *followInterval, Decompress: c, OnOpen: onOpen})
+		opts.Reader = nil
+		opts.Inputs = p.files
+		in = p.files
+		position = p.files.Position
+		total = p.files.Size()
+	case *follow:
+		return nil, errors.New("--follow requires --input")
+	case c != input.None:
+		r := input.NewReader(os.Stdin, c)
+		opts.Reader = r
+		in = r
+		position = r.Position
+	}
+	if *checkpointFile != "" {
+		if *workers > 1 || *execEach {
+			// The throttler is ready for the next data chunk while earlier ones are still being processed.
+			return nil, errors.New("--checkpoint requires data chunks to be processed one at a time, it can't be used with --workers > 1 or --exec_each")
+		}
+		opts.Checkpointer = checkpoint.New(*checkpointFile)
+	}
+	if *resume {
+		if *checkpointFile == "" {
+			return nil, errors.New("--resume requires --checkpoint")
+		}
+		if opts.Start, err = resumeFrom(in, *checkpointFile); err != nil {
+			return nil, err
+		}
+	}
+	if *metricsTextfile != "" && *metricsInterval <= 0 {
+		return nil, errors.New("--metrics_textfile requires --metrics_interval > 0")
+	}
+	if *progress > 0 || *metricsListen != "" || *metricsTextfile != "" {
+		p.stats = stats.New(total, opts.Start.Offset)
+		// Progress through compressed input is measured against its compressed size.
+		p.stats.SetPosition(position)
+		opts.Stats = p.stats
+	}
+	eopts, err := expectOptions()
+	if err != nil {
+		return nil, err
+	}
+	eopts.Stats = p.stats
+	switch *header {
+	case "none":
+	case "chunk", "start":
+		if *resume {
+			return nil, errors.New("--header requires reading each input from the start, it can't be used with --resume")
+		}
+		opts.Header = new(throttler.Header)
+		opts.HeaderEach = *header == "chunk"
+		if !opts.HeaderEach {
+			eopts.Header = opts.Header.Get
+		}
+	default:
+		return nil, fmt.Errorf("unknown header mode %q", *header)
+	}
+	var t throttler.Throttler
+	if *execEach {
+		t, err = execeach.New(execeach.Options{Command: flag.Args(), Concurrency: int(*workers), Header: eopts.Header})
+	} else {
+		delim := delimiterOptions{mode: *expectDelimiterMode, term: *expectDelimiterReplacement}
+		t, err = newThrottler(flag.Args(), int(*expectSize), *expectSplit, delim, eopts, int(*workers), *ordered)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if k, ok := t.(groupKiller); ok {
+		p.cmds = k
+	}
+	if *targetLatency > 0 && len(flag.Args()) == 0 {
+		return nil, errors.New("--target_latency requires a wrapped command")
+	}
+	if *targetLatency > 0 && *workers > 1 {
+		// The next worker may be ready long before the one the last data chunk was written to responds.
+		return nil, errors.New("--target_latency requires data chunks to be processed one at a time, it can't be used with --workers > 1")
+	}
+	aopts := adaptive.Options{
+		Target:   *targetLatency,
+		MinRate:  *adaptiveMinRate,
+		MaxRate:  *adaptiveMaxRate,
+		Increase: *adaptiveIncrease,
+		Decrease: *adaptiveDecrease,
+		Stats:    p.stats,
+	}
+	if t, err = newAdaptive(t, aopts); err != nil {
+		return nil, err
+	}
+	// Rate limits can only be changed at runtime if the throttler is wrapped from the start.
+	p.settings = config.Settings{
+		Interval:  *interval,
+		Rate:      *rate,
+		ByteRate:  *byteRate,
+		Burst:     *burst,
+		ByteBurst: *byteBurst,
+	}
+	ropts := rateLimits(p.settings)
+	ropts.Stats = p.stats
+	if p.rl = newRateLimit(t, ropts, *configFile != "" || *controlSocket != ""); p.rl != nil {
+		t = p.rl
+	}
+	opts.Throttler = t
+	if *deadLetter != "" {
+		if p.deadLetter, err = os.OpenFile(*deadLetter, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			return nil, err
+		}
+		opts.DeadLetter = p.deadLetter
+	}
+	if *oversizeRejects != "" {
+		if p.rejects, err = os.OpenFile(*oversizeRejects, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			return nil, err
+		}
+		opts.Rejects = p.rejects
+	}
+	p.Runner = runner.New(opts)
+	return p, nil
+}
+
+// serveMetrics serves metrics over HTTP at /metrics,
+// the returned listener must be closed once the pipeline is done.
+func serveMetrics(p *pipeline, addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(p.stats))
+	go http.Serve(l, mux)
+	return l, nil
+}
+
+// serveControl accepts control commands for a pipeline on a Unix-domain socket,
+// the returned listener must be closed once the pipeline is done.
+func serveControl(p *pipeline, path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	var rl control.RateLimiter
+	if p.rl != nil {
+		rl = p.rl
+	}
+	go control.New(p.Runner, rl).Serve(l)
+	return l, nil
 }
 
 func run() error {
-	r, err := newRunner()
+	p, err := newRunner()
 	if err != nil {
 		return err
 	}
-	return r.Run()
+	defer p.close()
+	handleSignals(p)
+	if *controlSocket != "" {
+		l, err := serveControl(p, *controlSocket)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+	}
+	if *metricsListen != "" {
+		l, err := serveMetrics(p, *metricsListen)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+	}
+	if *metricsTextfile != "" {
+		defer metrics.WriteFileEvery(*metricsTextfile, p.stats, *metricsInterval, os.Stderr)()
+	}
+	if *progress > 0 {
+		stop := p.stats.Report(os.Stderr, *progress)
+		defer func() {
+			stop()
+			fmt.Fprintln(os.Stderr, p.stats.Snapshot())
+		}()
+	}
+	err = p.Run()
+	if errors.Is(err, split.ErrTokenTooLarge) {
+		err = fmt.Errorf("%w, see --max_chunk_size and --oversize", err)
+	}
+	return err
+}
+
+// ctl sends a command to a running pt's control socket and prints the response.
+func ctl(args []string) error {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	sock := fs.String("control_socket", "", "path of the control socket of the pt to send a command to")
+	fs.Parse(args)
+	if *sock == "" || fs.NArg() == 0 {
+		return errors.New("usage: pt ctl --control_socket=PATH command [args...]")
+	}
+	resp, err := control.Send(*sock, strings.Join(fs.Args(), " "))
+	if err != nil {
+		return err
+	}
+	if resp != "" {
+		fmt.Println(resp)
+	}
+	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(exitCode(ctl(os.Args[2:])))
+	}
 	flag.Parse()
 	os.Exit(exitCode(run()))
 }
@@ -1,22 +1,38 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"flag"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/hazaelsan/pipe-throttler/checkpoint"
+	"github.com/hazaelsan/pipe-throttler/config"
+	"github.com/hazaelsan/pipe-throttler/runner"
+	"github.com/hazaelsan/pipe-throttler/split"
+	"github.com/hazaelsan/pipe-throttler/stats"
+	"github.com/hazaelsan/pipe-throttler/throttler/adaptive"
 	"github.com/hazaelsan/pipe-throttler/throttler/dummy"
+	"github.com/hazaelsan/pipe-throttler/throttler/execeach"
+	"github.com/hazaelsan/pipe-throttler/throttler/expect"
+	"github.com/hazaelsan/pipe-throttler/throttler/ratelimit"
+	"github.com/kylelemons/godebug/pretty"
 )
 
 func TestExitCode(t *testing.T) {
 	testdata := map[error]int{
 		exec.Command("sh", "-c", "exit 123").Run(): 123,
 		errors.New("some error"):                   1,
-		nil:                                        0,
+		&execeach.FailedError{Failed: 1, Total: 2}: 123,
+		nil: 0,
 	}
 	for err, want := range testdata {
 		if got := exitCode(err); got != want {
@@ -27,12 +43,15 @@ func TestExitCode(t *testing.T) {
 
 func TestNewThrottler(t *testing.T) {
 	testdata := []struct {
-		name  string
-		args  []string
-		size  int
-		split string
-		dummy bool
-		ok    bool
+		name    string
+		args    []string
+		size    int
+		split   string
+		delim   delimiterOptions
+		workers int
+		dummy   bool
+		pool    bool
+		ok      bool
 	}{
 		{
 			name:  "dummy",
@@ -51,6 +70,21 @@ func TestNewThrottler(t *testing.T) {
 			split: "...",
 			ok:    true,
 		},
+		{
+			name:    "pool",
+			args:    []string{"foo"},
+			split:   "...",
+			workers: 2,
+			pool:    true,
+			ok:      true,
+		},
+		{
+			name:  "strip delimiter",
+			args:  []string{"foo"},
+			split: "\r?\n",
+			delim: delimiterOptions{mode: "strip"},
+			ok:    true,
+		},
 		{
 			name: "empty split",
 			args: []string{"foo"},
@@ -60,9 +94,15 @@ func TestNewThrottler(t *testing.T) {
 			args:  []string{"foo"},
 			split: "?bad",
 		},
+		{
+			name:  "size delimiter",
+			args:  []string{"foo"},
+			size:  3,
+			delim: delimiterOptions{mode: "move"},
+		},
 	}
 	for _, tt := range testdata {
-		pt, err := newThrottler(tt.args, tt.size, tt.split, false, 0)
+		pt, err := newThrottler(tt.args, tt.size, tt.split, tt.delim, expect.Options{}, tt.workers, false)
 		if err != nil {
 			if tt.ok {
 				t.Errorf("newThrottler(%v) error = %v", tt.name, err)
@@ -75,6 +115,453 @@ func TestNewThrottler(t *testing.T) {
 		if _, ok := pt.(*dummy.Dummy); ok != tt.dummy {
 			t.Errorf("newThrottler(%v) = %T", tt.name, pt)
 		}
+		if _, ok := pt.(*expect.Pool); ok != tt.pool {
+			t.Errorf("newThrottler(%v) = %T", tt.name, pt)
+		}
+	}
+}
+
+func TestLiteralSplitFunc(t *testing.T) {
+	testdata := []struct {
+		name   string
+		delims []string
+		input  string
+		want   []string
+		ok     bool
+	}{
+		{
+			name:   "escapes",
+			delims: []string{`\r\n`, `\x00`},
+			input:  "a\r\nb\x00c",
+			want:   []string{"a\r\n", "b\x00", "c"},
+			ok:     true,
+		},
+		{
+			name:   "quotes",
+			delims: []string{`"`},
+			input:  `a"b`,
+			want:   []string{`a"`, "b"},
+			ok:     true,
+		},
+		{
+			name: "none",
+		},
+		{
+			name:   "bad escape",
+			delims: []string{`\q`},
+		},
+		{
+			name:   "empty",
+			delims: []string{""},
+		},
+	}
+	for _, tt := range testdata {
+		f, _, err := literalSplitFunc(tt.delims)
+		if (err == nil) != tt.ok {
+			t.Errorf("literalSplitFunc(%v) error = %v", tt.name, err)
+		}
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(strings.NewReader(tt.input))
+		s.Split(f)
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("literalSplitFunc(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestDelimiterOptions(t *testing.T) {
+	testdata := []struct {
+		name  string
+		opts  delimiterOptions
+		delim split.Delimiter
+		input string
+		want  []string
+		ok    bool
+	}{
+		{
+			name:  "unset",
+			delim: split.NewlineDelimiter,
+			input: "a\r\nb\n",
+			want:  []string{"a\r\n", "b\n"},
+			ok:    true,
+		},
+		{
+			name:  "keep",
+			opts:  delimiterOptions{mode: "keep"},
+			input: "a\r\nb\n",
+			want:  []string{"a\r\n", "b\n"},
+			ok:    true,
+		},
+		{
+			name:  "replace",
+			opts:  delimiterOptions{mode: "replace", term: `\n`},
+			delim: split.NewlineDelimiter,
+			input: "a\r\nb\n",
+			want:  []string{"a\n", "b\n"},
+			ok:    true,
+		},
+		{
+			name:  "move",
+			opts:  delimiterOptions{mode: "move"},
+			delim: split.NewlineDelimiter,
+			input: "a\r\nb\n",
+			want:  []string{"a", "\r\nb"},
+			ok:    true,
+		},
+		{
+			name:  "bad mode",
+			opts:  delimiterOptions{mode: "bogus"},
+			delim: split.NewlineDelimiter,
+		},
+		{
+			name: "no delimiter",
+			opts: delimiterOptions{mode: "strip"},
+		},
+		{
+			name:  "bad replacement",
+			opts:  delimiterOptions{mode: "replace", term: `\q`},
+			delim: split.NewlineDelimiter,
+		},
+	}
+	for _, tt := range testdata {
+		f, err := tt.opts.wrap(split.ByDelimiter([]byte("\n")), tt.delim)
+		if (err == nil) != tt.ok {
+			t.Errorf("wrap(%v) error = %v", tt.name, err)
+		}
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(strings.NewReader(tt.input))
+		s.Split(f)
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("wrap(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestDelimiterOptions_delimiter(t *testing.T) {
+	testdata := []struct {
+		name  string
+		opts  delimiterOptions
+		delim split.Delimiter
+		token string
+		want  int
+	}{
+		{
+			name:  "unset",
+			delim: split.NewlineDelimiter,
+			token: "a\r\n",
+			want:  2,
+		},
+		{
+			name:  "keep",
+			opts:  delimiterOptions{mode: "keep"},
+			delim: split.NewlineDelimiter,
+			token: "a\n",
+			want:  1,
+		},
+		{
+			name:  "strip",
+			opts:  delimiterOptions{mode: "strip"},
+			delim: split.NewlineDelimiter,
+			token: "a\n",
+		},
+		{
+			name:  "replace",
+			opts:  delimiterOptions{mode: "replace", term: `|\n`},
+			delim: split.NewlineDelimiter,
+			token: "a|\n",
+			want:  2,
+		},
+		{
+			name:  "move",
+			opts:  delimiterOptions{mode: "move"},
+			delim: split.NewlineDelimiter,
+			token: "\na\n",
+		},
+		{
+			name:  "no delimiter",
+			token: "a\n",
+		},
+	}
+	for _, tt := range testdata {
+		d := tt.opts.delimiter(tt.delim)
+		got := 0
+		if d != nil {
+			got = d([]byte(tt.token))
+		}
+		if got != tt.want {
+			t.Errorf("delimiter(%v)(%q) = %v, want %v", tt.name, tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestOversizePolicy(t *testing.T) {
+	testdata := []struct {
+		name   string
+		policy string
+		mode   string
+		size   int
+		want   split.OversizePolicy
+		ok     bool
+	}{
+		{"error", "error", "json", 0, split.OversizeError, true},
+		{"regexp", "truncate", "regexp", 0, split.OversizeTruncate, true},
+		{"size", "split", "regexp", 16, split.OversizeSplit, true},
+		{"literal", "skip", "literal", 0, split.OversizeSkip, true},
+		{"bad policy", "bogus", "regexp", 0, 0, false},
+		{"unsupported mode", "skip", "json", 0, 0, false},
+		{"size too large", "error", "regexp", 17, 0, false},
+	}
+	for _, tt := range testdata {
+		got, err := oversizePolicy(tt.policy, tt.mode, tt.size, 16)
+		if (err == nil) != tt.ok {
+			t.Errorf("oversizePolicy(%v) error = %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("oversizePolicy(%v) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSizeSplitFunc(t *testing.T) {
+	testdata := []struct {
+		boundary string
+		want     []string
+		ok       bool
+	}{
+		{"byte", []string{"h\xc3", "\xa9"}, true},
+		{"rune", []string{"h", "é"}, true},
+		{"grapheme", []string{"h", "é"}, true},
+		{"word", nil, false},
+	}
+	for _, tt := range testdata {
+		f, err := sizeSplitFunc(2, tt.boundary)
+		if (err == nil) != tt.ok {
+			t.Errorf("sizeSplitFunc(%v) error = %v", tt.boundary, err)
+		}
+		if err != nil {
+			continue
+		}
+		s := bufio.NewScanner(strings.NewReader("hé"))
+		s.Split(f)
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("sizeSplitFunc(%v) -got +want:\n%v", tt.boundary, diff)
+		}
+	}
+}
+
+func TestLengthSplitFunc(t *testing.T) {
+	testdata := []struct {
+		name  string
+		size  int
+		order string
+		ok    bool
+	}{
+		{"big", 4, "big", true},
+		{"little", 2, "little", true},
+		{"bad size", 3, "big", false},
+		{"bad order", 4, "middle", false},
+	}
+	for _, tt := range testdata {
+		if _, err := lengthSplitFunc(tt.size, tt.order, false, 0); (err == nil) != tt.ok {
+			t.Errorf("lengthSplitFunc(%v) error = %v", tt.name, err)
+		}
+	}
+}
+
+func TestNewRateLimit(t *testing.T) {
+	testdata := []struct {
+		name      string
+		rate      float64
+		byteRate  float64
+		always    bool
+		ratelimit bool
+	}{
+		{
+			name: "unlimited",
+		},
+		{
+			name:      "rate",
+			rate:      1,
+			ratelimit: true,
+		},
+		{
+			name:      "byte rate",
+			byteRate:  1,
+			ratelimit: true,
+		},
+		{
+			name:      "always",
+			always:    true,
+			ratelimit: true,
+		},
+	}
+	for _, tt := range testdata {
+		opts := ratelimit.Options{Rate: tt.rate, ByteRate: tt.byteRate}
+		if rl := newRateLimit(dummy.New(os.Stdout), opts, tt.always); (rl != nil) != tt.ratelimit {
+			t.Errorf("newRateLimit(%v) = %v", tt.name, rl)
+		}
+	}
+}
+
+func TestNewAdaptive(t *testing.T) {
+	testdata := []struct {
+		name     string
+		opts     adaptive.Options
+		adaptive bool
+		ok       bool
+	}{
+		{
+			name: "disabled",
+			ok:   true,
+		},
+		{
+			name:     "target",
+			opts:     adaptive.Options{Target: time.Second},
+			adaptive: true,
+			ok:       true,
+		},
+		{
+			name: "bad decrease",
+			opts: adaptive.Options{Target: time.Second, Decrease: 2},
+		},
+	}
+	for _, tt := range testdata {
+		got, err := newAdaptive(dummy.New(os.Stdout), tt.opts)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("newAdaptive(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("newAdaptive(%v) error = nil", tt.name)
+		}
+		if _, ok := got.(*adaptive.Adaptive); ok != tt.adaptive {
+			t.Errorf("newAdaptive(%v) = %T", tt.name, got)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pt.conf")
+	p := &pipeline{
+		Runner:   runner.New(runner.Options{Reader: strings.NewReader(""), SplitFunc: bufio.ScanLines}),
+		rl:       newRateLimit(dummy.New(os.Stdout), ratelimit.Options{}, true),
+		settings: config.Settings{Interval: 2 * time.Second, Rate: 5},
+	}
+	if err := p.reload(path); err == nil {
+		t.Error("reload(missing) error = nil")
+	}
+	if err := ioutil.WriteFile(path, []byte("interval = 1s\nrate = 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.reload(path); err != nil {
+		t.Errorf("reload() error = %v", err)
+	}
+	if got := p.Status().WaitDuration; got != time.Second {
+		t.Errorf("reload() interval = %v, want %v", got, time.Second)
+	}
+	// Settings missing from the file are reset to their command-line values.
+	if err := ioutil.WriteFile(path, []byte("rate = 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.reload(path); err != nil {
+		t.Errorf("reload() error = %v", err)
+	}
+	if got := p.Status().WaitDuration; got != 2*time.Second {
+		t.Errorf("reload() interval = %v, want %v", got, 2*time.Second)
+	}
+}
+
+func TestInputSize(t *testing.T) {
+	f, err := ioutil.TempFile("", "pt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString("foo\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := inputSize(f); got != 4 {
+		t.Errorf("inputSize(file) = %v, want 4", got)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+	if got := inputSize(pr); got != 0 {
+		t.Errorf("inputSize(pipe) = %v, want 0", got)
+	}
+}
+
+func TestResumeFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input")
+	if err := ioutil.WriteFile(input, []byte("foo\nbar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "checkpoint")
+	want := checkpoint.State{Offset: 4, Chunks: 1}
+	if err := checkpoint.New(path).Save(want); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := resumeFrom(f, path)
+	if err != nil {
+		t.Errorf("resumeFrom() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("resumeFrom() = %v, want %v", got, want)
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "bar\n" {
+		t.Errorf("resumeFrom() input = %q, want %q", b, "bar\n")
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+	if _, err := resumeFrom(pr, path); err == nil {
+		t.Error("resumeFrom(pipe) error = nil")
 	}
 }
 
@@ -83,15 +570,42 @@ func TestNewRunner(t *testing.T) {
 	defer func() {
 		os.Args = osArgs
 		flag.Parse()
+		inputs = nil
 	}()
 	testdata := []struct {
-		name     string
-		size     int
-		split    string
-		eSplit   string
-		interval time.Duration
-		args     []string
-		ok       bool
+		name        string
+		size        int
+		split       string
+		splitMode   string
+		csvDelim    string
+		header      string
+		delimMode   string
+		eDelimMode  string
+		eSplit      string
+		recordStart string
+		onTimeout   string
+		restart     string
+		script      string
+		ready       string
+		eError      string
+		eSuccess    string
+		deadLetter  string
+		oversize    string
+		eOversize   string
+		boundary    string
+		rejects     string
+		execEach    bool
+		checkpoint  string
+		textfile    string
+		textfileInt time.Duration
+		workers     uint
+		input       []string
+		follow      bool
+		decompress  string
+		interval    time.Duration
+		latency     time.Duration
+		args        []string
+		ok          bool
 	}{
 		{
 			name:  "good",
@@ -111,13 +625,360 @@ func TestNewRunner(t *testing.T) {
 			eSplit: "?bad",
 			args:   []string{"invalid"},
 		},
+		{
+			name:        "record start",
+			split:       "\n",
+			recordStart: "^\\d+ ",
+			ok:          true,
+		},
+		{
+			name:        "bad record start",
+			split:       "\n",
+			recordStart: "?bad",
+		},
+		{
+			name:      "bad timeout policy",
+			split:     "\n",
+			onTimeout: "bogus",
+		},
+		{
+			name:    "bad restart policy",
+			split:   "\n",
+			restart: "bogus",
+		},
+		{
+			name:  "bad expect ready",
+			split: "\n",
+			ready: "?bad",
+		},
+		{
+			name:   "bad expect error",
+			split:  "\n",
+			eError: "?bad",
+		},
+		{
+			name:     "bad expect success",
+			split:    "\n",
+			eSuccess: "?bad",
+		},
+		{
+			name:       "bad dead letter",
+			split:      "\n",
+			deadLetter: "/nonexistent/dead_letter",
+		},
+		{
+			name:     "exec each",
+			split:    "\n",
+			execEach: true,
+			args:     []string{"cat"},
+			ok:       true,
+		},
+		{
+			name:     "exec each without command",
+			split:    "\n",
+			execEach: true,
+		},
+		{
+			name:      "json",
+			splitMode: "json",
+			ok:        true,
+		},
+		{
+			name:      "csv",
+			splitMode: "csv",
+			csvDelim:  ";",
+			ok:        true,
+		},
+		{
+			name:      "length",
+			splitMode: "length",
+			ok:        true,
+		},
+		{
+			name:      "uvarint",
+			splitMode: "uvarint",
+			ok:        true,
+		},
+		{
+			name:      "null",
+			splitMode: "null",
+			ok:        true,
+		},
+		{
+			name:      "literal without delimiter",
+			splitMode: "literal",
+		},
+		{
+			name:      "bad csv delimiter",
+			splitMode: "csv",
+			csvDelim:  ";;",
+		},
+		{
+			name:   "header chunk",
+			split:  "\n",
+			header: "chunk",
+			ok:     true,
+		},
+		{
+			name:   "header start",
+			split:  "\n",
+			eSplit: "\n",
+			header: "start",
+			args:   []string{"cat"},
+			ok:     true,
+		},
+		{
+			name:   "bad header",
+			split:  "\n",
+			header: "bogus",
+		},
+		{
+			name:      "bad split mode",
+			split:     "\n",
+			splitMode: "bogus",
+		},
+		{
+			name:   "missing expect script",
+			split:  "\n",
+			script: "/nonexistent/script",
+		},
+		{
+			name:  "input",
+			split: "\n",
+			input: []string{"*.go", "split"},
+			ok:    true,
+		},
+		{
+			name:   "follow input",
+			split:  "\n",
+			input:  []string{"pt.go"},
+			follow: true,
+			ok:     true,
+		},
+		{
+			name:  "no matching input",
+			split: "\n",
+			input: []string{"/nonexistent/*"},
+		},
+		{
+			name:       "decompress input",
+			split:      "\n",
+			input:      []string{"pt.go"},
+			decompress: "gzip",
+			ok:         true,
+		},
+		{
+			name:       "bad decompress",
+			split:      "\n",
+			decompress: "xz",
+		},
+		{
+			name:   "follow without input",
+			split:  "\n",
+			follow: true,
+		},
+		{
+			name:      "strip delimiter",
+			split:     "\r?\n",
+			delimMode: "strip",
+			ok:        true,
+		},
+		{
+			name:      "bad delimiter mode",
+			split:     "\n",
+			delimMode: "bogus",
+		},
+		{
+			name:      "size delimiter",
+			size:      3,
+			split:     "\n",
+			delimMode: "move",
+		},
+		{
+			name:       "expect delimiter",
+			split:      "\n",
+			eSplit:     "\r\n",
+			eDelimMode: "replace",
+			args:       []string{"cat"},
+			ok:         true,
+		},
+		{
+			name:        "metrics textfile",
+			split:       "\n",
+			textfile:    "/nonexistent/pt.prom",
+			textfileInt: time.Second,
+			ok:          true,
+		},
+		{
+			name:     "metrics textfile without interval",
+			split:    "\n",
+			textfile: "/nonexistent/pt.prom",
+		},
+		{
+			name:       "checkpoint",
+			split:      "\n",
+			checkpoint: "/nonexistent/checkpoint",
+			ok:         true,
+		},
+		{
+			name:       "checkpoint with workers",
+			split:      "\n",
+			checkpoint: "/nonexistent/checkpoint",
+			workers:    2,
+			args:       []string{"cat"},
+		},
+		{
+			name:       "checkpoint with exec each",
+			split:      "\n",
+			checkpoint: "/nonexistent/checkpoint",
+			execEach:   true,
+			args:       []string{"cat"},
+		},
+		{
+			name:    "target latency",
+			split:   "\n",
+			eSplit:  "\n",
+			latency: time.Second,
+			args:    []string{"cat"},
+			ok:      true,
+		},
+		{
+			name:    "target latency with workers",
+			split:   "\n",
+			eSplit:  "\n",
+			latency: time.Second,
+			workers: 2,
+			args:    []string{"cat"},
+		},
+		{
+			name:     "target latency with exec each",
+			split:    "\n",
+			latency:  time.Second,
+			workers:  2,
+			execEach: true,
+			args:     []string{"cat"},
+		},
+		{
+			name:     "size boundary",
+			size:     3,
+			boundary: "grapheme",
+			ok:       true,
+		},
+		{
+			name:     "size boundary at max chunk size",
+			size:     bufio.MaxScanTokenSize,
+			boundary: "grapheme",
+		},
+		{
+			name: "byte size at max chunk size",
+			size: bufio.MaxScanTokenSize,
+			ok:   true,
+		},
+		{
+			name:     "bad size boundary",
+			size:     3,
+			boundary: "word",
+		},
+		{
+			name:     "oversize",
+			split:    "\n",
+			oversize: "skip",
+			ok:       true,
+		},
+		{
+			name:     "bad oversize",
+			split:    "\n",
+			oversize: "bogus",
+		},
+		{
+			name:      "bad expect oversize",
+			split:     "\n",
+			eSplit:    "\n",
+			eOversize: "bogus",
+			args:      []string{"cat"},
+		},
+		{
+			name:     "bad rejects",
+			split:    "\n",
+			oversize: "skip",
+			rejects:  "/nonexistent/rejects",
+		},
+		{
+			name:       "bad expect delimiter mode",
+			split:      "\n",
+			eSplit:     "\n",
+			eDelimMode: "bogus",
+			args:       []string{"cat"},
+		},
 	}
 	for _, tt := range testdata {
+		if tt.onTimeout == "" {
+			tt.onTimeout = "abort"
+		}
+		if tt.restart == "" {
+			tt.restart = "no"
+		}
+		if tt.decompress == "" {
+			tt.decompress = "none"
+		}
+		if tt.splitMode == "" {
+			tt.splitMode = "regexp"
+		}
+		if tt.csvDelim == "" {
+			tt.csvDelim = ","
+		}
+		if tt.header == "" {
+			tt.header = "none"
+		}
+		if tt.workers == 0 {
+			tt.workers = 1
+		}
+		if tt.boundary == "" {
+			tt.boundary = "byte"
+		}
+		if tt.oversize == "" {
+			tt.oversize = "error"
+		}
+		if tt.eOversize == "" {
+			tt.eOversize = "error"
+		}
+		if tt.delimMode == "" {
+			tt.delimMode = "keep"
+		}
+		if tt.eDelimMode == "" {
+			tt.eDelimMode = "keep"
+		}
 		os.Args = append(osArgs, tt.args...)
 		flag.Parse()
 		flag.Set("size", strconv.Itoa(tt.size))
 		flag.Set("split", tt.split)
+		flag.Set("split_mode", tt.splitMode)
+		flag.Set("csv_delimiter", tt.csvDelim)
+		flag.Set("header", tt.header)
+		flag.Set("delimiter_mode", tt.delimMode)
+		flag.Set("expect_delimiter_mode", tt.eDelimMode)
 		flag.Set("expect_split", tt.eSplit)
+		flag.Set("record_start", tt.recordStart)
+		flag.Set("on_timeout", tt.onTimeout)
+		flag.Set("restart", tt.restart)
+		flag.Set("expect_script", tt.script)
+		flag.Set("expect_ready", tt.ready)
+		flag.Set("expect_error", tt.eError)
+		flag.Set("expect_success", tt.eSuccess)
+		flag.Set("dead_letter", tt.deadLetter)
+		flag.Set("size_boundary", tt.boundary)
+		flag.Set("checkpoint", tt.checkpoint)
+		flag.Set("metrics_textfile", tt.textfile)
+		flag.Set("metrics_interval", tt.textfileInt.String())
+		flag.Set("workers", strconv.Itoa(int(tt.workers)))
+		flag.Set("oversize", tt.oversize)
+		flag.Set("expect_oversize", tt.eOversize)
+		flag.Set("oversize_rejects", tt.rejects)
+		flag.Set("exec_each", strconv.FormatBool(tt.execEach))
+		flag.Set("follow", strconv.FormatBool(tt.follow))
+		flag.Set("decompress", tt.decompress)
+		flag.Set("target_latency", tt.latency.String())
+		inputs = tt.input
 		if _, err := newRunner(); err != nil {
 			if tt.ok {
 				t.Errorf("newRunner(%v) error = %v", tt.name, err)
@@ -159,3 +1020,117 @@ func TestRun(t *testing.T) {
 		}
 	}
 }
+
+func TestServeMetrics(t *testing.T) {
+	p := &pipeline{stats: stats.New(0, 0)}
+	p.stats.AddChunk(3)
+	l, err := serveMetrics(p, "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("serveMetrics() error = %v", err)
+	}
+	defer l.Close()
+	resp, err := http.Get("http://" + l.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !strings.Contains(string(b), "\npt_chunks_written_total 1\n") {
+		t.Errorf("Get() = %q", b)
+	}
+}
+
+func TestCtl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pt.sock")
+	p := &pipeline{
+		Runner: runner.New(runner.Options{Reader: strings.NewReader(""), SplitFunc: bufio.ScanLines}),
+	}
+	l, err := serveControl(p, path)
+	if err != nil {
+		t.Fatalf("serveControl() error = %v", err)
+	}
+	defer l.Close()
+	testdata := []struct {
+		name string
+		args []string
+		ok   bool
+	}{
+		{
+			name: "no socket",
+			args: []string{"status"},
+		},
+		{
+			name: "no command",
+			args: []string{"--control_socket", path},
+		},
+		{
+			name: "status",
+			args: []string{"--control_socket", path, "status"},
+			ok:   true,
+		},
+		{
+			name: "pause",
+			args: []string{"--control_socket", path, "pause"},
+			ok:   true,
+		},
+		{
+			name: "rate without rate limiting",
+			args: []string{"--control_socket", path, "rate", "1"},
+		},
+	}
+	for _, tt := range testdata {
+		if err := ctl(tt.args); (err == nil) != tt.ok {
+			t.Errorf("ctl(%v) error = %v", tt.name, err)
+		}
+	}
+	if !p.Status().Paused {
+		t.Error("ctl(pause) did not pause")
+	}
+}
+
+func TestNewRunner_closeOnError(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("can't list open files:", err)
+	}
+	dir, err := ioutil.TempDir("", "pt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() {
+		flag.Set("dead_letter", "")
+		flag.Set("oversize_rejects", "")
+	}()
+	// Other tests leave flags set.
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := f.Value.(*stringList); !ok && !strings.HasPrefix(f.Name, "test.") {
+			f.Value.Set(f.DefValue)
+		}
+	})
+	inputs = nil
+	// The dead letter file is opened before the rejects file fails to open.
+	path := filepath.Join(dir, "dead_letter")
+	flag.Set("dead_letter", path)
+	flag.Set("oversize_rejects", "/nonexistent/rejects")
+	if _, err := newRunner(); err == nil || !strings.Contains(err.Error(), "rejects") {
+		t.Fatalf("newRunner() error = %v, want rejects file error", err)
+	}
+	// Files opened by other tests may be closed concurrently, only the dead letter file is looked for.
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range fds {
+		if target, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); target == path {
+			t.Errorf("newRunner() left %v open", path)
+		}
+	}
+}
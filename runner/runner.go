@@ -3,46 +3,354 @@ package runner
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"io"
 	"sync"
 	"time"
 
+	"github.com/hazaelsan/pipe-throttler/checkpoint"
+	"github.com/hazaelsan/pipe-throttler/split"
+	"github.com/hazaelsan/pipe-throttler/stats"
 	"github.com/hazaelsan/pipe-throttler/throttler"
 )
 
+// A Checkpointer durably records delivery progress.
+type Checkpointer interface {
+	Save(checkpoint.State) error
+}
+
+// Inputs is a sequence of input sources.
+type Inputs interface {
+	// Next returns the next input source, io.EOF if there are none left.
+	Next() (io.Reader, error)
+}
+
 // Options is a set of options to initialize a Runner.
 type Options struct {
 	// Reader is the input source for bytes to write.
 	Reader io.Reader
 
+	// Inputs, if set, is used instead of Reader to read from several input sources one after another,
+	// chunks of data never span input sources.
+	Inputs Inputs
+
 	// Throttler is the output throttler to rate-limit writes.
 	Throttler throttler.Throttler
 
 	// SplitFunc is the function used to split output from the wrapped program.
 	SplitFunc bufio.SplitFunc
 
+	// MaxChunkSize is the maximum size of a chunk of data as returned by SplitFunc, bufio.MaxScanTokenSize if <= 0.
+	MaxChunkSize int
+
+	// Oversize is what to do with chunks of data larger than MaxChunkSize, see split.WithMaxSize.
+	Oversize split.OversizePolicy
+
+	// Delimiter, if set, finds the delimiter at the end of chunks of data as split by SplitFunc,
+	// truncated chunks keep it, see split.WithMaxSize.
+	Delimiter split.Delimiter
+
+	// Rejects, if set, records the input making up chunks of data skipped with split.OversizeSkip.
+	Rejects io.Writer
+
 	// WaitDuration is how long to wait after the Throttler has indicated
 	// it's ready before writing the next chunk of data.
 	WaitDuration time.Duration
+
+	// Checkpointer, if set, records the progress made each time a chunk of data is acknowledged.
+	// A chunk is acknowledged once the Throttler is ready for the next one,
+	// or once the Throttler has stopped cleanly for the last one.
+	// The Throttler must therefore process one chunk at a time, e.g., not an expect.Pool or an execeach.ExecEach.
+	Checkpointer Checkpointer
+
+	// Start is the progress made by a previous run, Reader must be positioned at Start.Offset.
+	Start checkpoint.State
+
+	// Stats, if set, keeps track of progress and throughput statistics.
+	Stats *stats.Stats
+
+	// DeadLetter, if set, records chunks of data rejected by the Throttler as JSON lines,
+	// rejected chunks are otherwise fatal.
+	DeadLetter io.Writer
+
+	// Header, if set, receives the first chunk of data of each input source (e.g., a CSV header row)
+	// instead of it being written, for the Throttler to write it as needed.
+	Header *throttler.Header
+
+	// HeaderEach indicates whether Header is prepended to every chunk of data instead.
+	HeaderEach bool
+}
+
+// A DeadLetter is a chunk of data rejected by the Throttler.
+type DeadLetter struct {
+	// Chunk is the rejected chunk of data.
+	Chunk string `json:"chunk"`
+
+	// Reason is why the chunk was rejected.
+	Reason string `json:"reason"`
 }
 
 // New initializes a Runner.
 func New(opts Options) *Runner {
 	r := &Runner{
-		s:    bufio.NewScanner(opts.Reader),
-		t:    opts.Throttler,
-		wait: opts.WaitDuration,
+		in:      opts.Reader,
+		inputs:  opts.Inputs,
+		t:       opts.Throttler,
+		wait:    opts.WaitDuration,
+		cp:      opts.Checkpointer,
+		state:   opts.Start,
+		read:    opts.Start.Offset,
+		drained: make(chan struct{}),
+		stats:   opts.Stats,
+		header:  opts.Header,
+		each:    opts.HeaderEach,
+		max:     opts.MaxChunkSize,
 	}
-	r.s.Split(opts.SplitFunc)
+	if opts.DeadLetter != nil {
+		r.dl = json.NewEncoder(opts.DeadLetter)
+	}
+	r.cond = sync.NewCond(&r.mu)
+	if r.max <= 0 {
+		r.max = bufio.MaxScanTokenSize
+	}
+	r.split = r.count(split.WithMaxSize(opts.SplitFunc, opts.Delimiter, r.max, opts.Oversize, opts.Rejects))
 	return r
 }
 
 // A Runner handles reading and writing to/from file descriptors.
 type Runner struct {
-	s    *bufio.Scanner
-	t    throttler.Throttler
-	wait time.Duration
-	wg   sync.WaitGroup
+	in       io.Reader
+	inputs   Inputs
+	split    bufio.SplitFunc
+	max      int
+	t        throttler.Throttler
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	cond     *sync.Cond
+	wait     time.Duration
+	paused   bool
+	steps    int
+	skip     int64
+	skipped  int64
+	draining bool
+	drained  chan struct{}
+	cp       Checkpointer
+	state    checkpoint.State
+	read     int64
+	pending  *chunk
+	stats    *stats.Stats
+	dl       *json.Encoder
+	header   *throttler.Header
+	each     bool
+	input    string
+	inputNum int
+	numInput int
+}
+
+// Status is a snapshot of a Runner's progress and settings.
+type Status struct {
+	// Chunks is how many chunks of data have been acknowledged.
+	Chunks int64
+
+	// Offset is how many bytes of input have been consumed by acknowledged or skipped chunks.
+	Offset int64
+
+	// Skipped is how many chunks of data have been skipped.
+	Skipped int64
+
+	// Paused indicates whether writing data is paused.
+	Paused bool
+
+	// Draining indicates whether the Runner is shutting down after the current chunk.
+	Draining bool
+
+	// WaitDuration is how long to wait after the Throttler is ready before writing the next chunk of data.
+	WaitDuration time.Duration
+
+	// Input is the name of the input source being read, if set via SetInput.
+	Input string
+
+	// InputNum is which of the Inputs input sources is being read, starting at 1.
+	InputNum, Inputs int
+}
+
+// A chunk is a chunk of data read from the input.
+type chunk struct {
+	b []byte
+
+	// offset is the input offset right after the chunk.
+	offset int64
+}
+
+// action is what to do with the next chunk of data.
+type action int
+
+const (
+	actWrite action = iota
+	actSkip
+	actDrain
+)
+
+// count wraps a SplitFunc to keep track of how much input has been consumed.
+func (r *Runner) count(f bufio.SplitFunc) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		advance, token, err = f(data, atEOF)
+		r.read += int64(advance)
+		return advance, token, err
+	}
+}
+
+// Pause stops writing data after the current chunk until Resume is called.
+func (r *Runner) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = true
+}
+
+// Resume resumes writing data after Pause.
+func (r *Runner) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = false
+	r.steps = 0
+	r.cond.Broadcast()
+}
+
+// Step lets one more chunk of data through while paused.
+func (r *Runner) Step() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paused {
+		r.steps++
+		r.cond.Broadcast()
+	}
+}
+
+// Skip discards the next n chunks of data instead of writing them,
+// skipped chunks are not acknowledged but still count as consumed input.
+func (r *Runner) Skip(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skip += n
+}
+
+// Drain stops reading any more data, Run returns once the current chunk has been acknowledged.
+func (r *Runner) Drain() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.draining {
+		return
+	}
+	r.draining = true
+	close(r.drained)
+	r.cond.Broadcast()
+}
+
+// SetWaitDuration changes how long to wait after the Throttler is ready before writing the next chunk of data.
+func (r *Runner) SetWaitDuration(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.wait = d
+}
+
+// Status returns a snapshot of the Runner's progress and settings.
+func (r *Runner) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Status{
+		Chunks:       r.state.Chunks,
+		Offset:       r.state.Offset,
+		Skipped:      r.skipped,
+		Paused:       r.paused,
+		Draining:     r.draining,
+		WaitDuration: r.wait,
+		Input:        r.input,
+		InputNum:     r.inputNum,
+		Inputs:       r.numInput,
+	}
+}
+
+// SetInput records the input source being read, e.g., an input file, the nth of a total number of them,
+// it's reported by Status and Stats.
+func (r *Runner) SetInput(name string, n, total int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.input, r.inputNum, r.numInput = name, n, total
+	r.stats.SetInput(name, n, total)
+}
+
+// next blocks while the Runner is paused, unless the next chunk of data is to be skipped,
+// returns how long to wait before writing the next chunk of data and what to do with it.
+func (r *Runner) next() (time.Duration, action) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.paused && r.steps == 0 && r.skip == 0 && !r.draining {
+		r.cond.Wait()
+	}
+	switch {
+	case r.draining:
+		return 0, actDrain
+	case r.skip > 0:
+		r.skip--
+		return 0, actSkip
+	}
+	if r.paused {
+		r.steps--
+	}
+	return r.wait, actWrite
+}
+
+// skipChunk accounts for a skipped chunk,
+// its input is considered consumed along with the pending chunk, if any.
+func (r *Runner) skipChunk(ch chunk) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped++
+	if r.pending != nil {
+		r.pending.offset = ch.offset
+		return
+	}
+	r.state.Offset = ch.offset
+}
+
+// ack acknowledges the pending chunk, if any.
+func (r *Runner) ack() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending == nil {
+		return nil
+	}
+	r.state.Offset = r.pending.offset
+	r.state.Chunks++
+	r.pending = nil
+	if r.cp == nil {
+		return nil
+	}
+	return r.cp.Save(r.state)
+}
+
+// reject records the chunks of data in a rejection error in the dead letter file,
+// returns err if it's not a rejection or there's no dead letter file.
+func (r *Runner) reject(err error) error {
+	var rejs throttler.Rejections
+	var rej *throttler.Rejection
+	switch {
+	case r.dl == nil:
+		return err
+	case errors.As(err, &rej):
+		rejs = throttler.Rejections{rej}
+	case errors.As(err, &rejs):
+	default:
+		return err
+	}
+	for _, rej := range rejs {
+		r.stats.AddRejected()
+		if err := r.dl.Encode(DeadLetter{string(rej.Chunk), rej.Reason}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Run copies bytes from the source reader to the throttled destination.
@@ -50,43 +358,142 @@ func (r *Runner) Run() error {
 	if err := r.t.Start(); err != nil {
 		return err
 	}
-	c := make(chan []byte)
-	errc := make(chan error)
+	c := make(chan chunk)
+	// Both the reader and the writer can fail, neither may block on reporting it.
+	errc := make(chan error, 2)
 	go r.reader(c, errc)
+	r.wg.Add(1)
 	go r.writer(c, errc)
 	if err := <-errc; err != nil {
+		r.stats.AddError()
+		// The Throttler can't be stopped until the writer is done with it and has told it there's no more data,
+		// which it does as soon as the reader closes c on error.
+		r.wg.Wait()
 		r.t.Stop()
 		return err
 	}
 	r.wg.Wait()
-	return r.t.Stop()
+	err := r.reject(r.t.Stop())
+	if err == nil {
+		err = r.ack()
+	}
+	if err != nil {
+		r.stats.AddError()
+	}
+	return err
 }
 
-func (r *Runner) reader(c chan<- []byte, errc chan<- error) {
-	r.wg.Add(1)
-	defer r.wg.Done()
+// reader reads chunks of data until the input is exhausted or the Runner is drained.
+func (r *Runner) reader(c chan<- chunk, errc chan<- error) {
 	defer close(c)
-	for r.s.Scan() {
-		c <- r.s.Bytes()
+	if r.inputs == nil {
+		if _, err := r.scan(r.in, c); err != nil {
+			errc <- err
+		}
+		return
 	}
-	if err := r.s.Err(); err != nil {
-		errc <- err
+	for {
+		in, err := r.inputs.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			errc <- err
+			return
+		}
+		drained, err := r.scan(in, c)
+		if err != nil {
+			errc <- err
+			return
+		}
+		if drained {
+			return
+		}
 	}
 }
 
-func (r *Runner) writer(c <-chan []byte, errc chan<- error) {
-	r.wg.Add(1)
+// scan reads chunks of data from a single input source,
+// returns whether the Runner was drained before the input source was exhausted.
+func (r *Runner) scan(in io.Reader, c chan<- chunk) (bool, error) {
+	s := bufio.NewScanner(in)
+	s.Buffer(nil, r.max)
+	s.Split(r.split)
+	first := r.header != nil
+	for s.Scan() {
+		// The scanner may overwrite its buffer while the chunk is being written.
+		b := append([]byte(nil), s.Bytes()...)
+		if first {
+			first = false
+			r.header.Set(b)
+			continue
+		}
+		if r.each {
+			b = append(append([]byte(nil), r.header.Get()...), b...)
+		}
+		select {
+		case c <- chunk{b, r.read}:
+		case <-r.drained:
+			return true, nil
+		}
+	}
+	return false, s.Err()
+}
+
+// writer writes chunks of data until there are none left or the Runner is drained,
+// the Throttler is then told there's no more data.
+func (r *Runner) writer(c <-chan chunk, errc chan<- error) {
 	defer r.wg.Done()
-	defer r.t.DoneRead()
+	err := r.write(c)
+	if dErr := r.t.DoneRead(); err == nil {
+		err = dErr
+	}
+	errc <- err
+}
+
+func (r *Runner) write(c <-chan chunk) error {
+	// ready indicates whether the Throttler is ready for the next chunk.
+	ready := false
 	for {
-		b, ok := <-c
+		var ch chunk
+		var ok bool
+		select {
+		case ch, ok = <-c:
+		case <-r.drained:
+		}
 		if !ok {
-			errc <- nil
-			return
+			return nil
 		}
-		if err := throttler.Write(r.t, b, r.wait); err != nil {
-			errc <- err
-			return
+		if !ready {
+			start := time.Now()
+			err := r.t.Wait()
+			r.stats.AddWait(time.Since(start))
+			if err := r.reject(err); err != nil {
+				return err
+			}
+			if err := r.ack(); err != nil {
+				return err
+			}
+			ready = true
+		}
+		d, act := r.next()
+		switch act {
+		case actDrain:
+			return nil
+		case actSkip:
+			r.skipChunk(ch)
+			r.stats.SetOffset(ch.offset)
+			continue
+		}
+		time.Sleep(d)
+		r.stats.AddSleep(d)
+		if _, err := r.t.Write(ch.b); err != nil {
+			return err
 		}
+		r.stats.AddChunk(len(ch.b))
+		r.stats.SetOffset(ch.offset)
+		r.mu.Lock()
+		r.pending = &ch
+		r.mu.Unlock()
+		ready = false
 	}
 }
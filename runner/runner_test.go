@@ -1,19 +1,28 @@
 package runner
 
 import (
+	"bytes"
+	"compress/gzip"
 	"errors"
+	"fmt"
 	"io"
 	"regexp"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/hazaelsan/pipe-throttler/checkpoint"
+	"github.com/hazaelsan/pipe-throttler/input"
 	"github.com/hazaelsan/pipe-throttler/split"
+	"github.com/hazaelsan/pipe-throttler/stats"
+	"github.com/hazaelsan/pipe-throttler/throttler"
 	"github.com/hazaelsan/pipe-throttler/throttler/dummy"
+	"github.com/hazaelsan/pipe-throttler/throttler/expect"
 	"github.com/kylelemons/godebug/pretty"
 )
 
 var (
+	errDone  = errors.New("done read error")
 	errRead  = errors.New("read error")
 	errStart = errors.New("start error")
 	errWrite = errors.New("write error")
@@ -33,6 +42,16 @@ func (a *appendWriter) Close() error {
 	return nil
 }
 
+type checkpointer struct {
+	s   []checkpoint.State
+	err error
+}
+
+func (c *checkpointer) Save(s checkpoint.State) error {
+	c.s = append(c.s, s)
+	return c.err
+}
+
 type badReader struct{}
 
 func (badReader) Read([]byte) (int, error) {
@@ -47,6 +66,14 @@ func (*badThrottler) Start() error {
 	return errStart
 }
 
+type doneThrottler struct {
+	*dummy.Dummy
+}
+
+func (*doneThrottler) DoneRead() error {
+	return errDone
+}
+
 func newRunner(r io.Reader, w io.WriteCloser) *Runner {
 	opts := Options{
 		Reader:       r,
@@ -95,6 +122,16 @@ func TestRun(t *testing.T) {
 			want: []string{"foo\n"},
 			err:  errWrite,
 		},
+		{
+			name: "done read error",
+			f: func(w *appendWriter) *Runner {
+				r := newRunner(strings.NewReader(input), w)
+				r.t = &doneThrottler{dummy.New(w)}
+				return r
+			},
+			want: []string{"foo\n", "bar baz\n", "quux"},
+			err:  errDone,
+		},
 	}
 	for _, tt := range testdata {
 		w := new(appendWriter)
@@ -107,3 +144,505 @@ func TestRun(t *testing.T) {
 		}
 	}
 }
+
+func TestRun_checkpoint(t *testing.T) {
+	var errSave = errors.New("save error")
+	input := "foo\nbar baz\nquux"
+	testdata := []struct {
+		name  string
+		start checkpoint.State
+		err   error
+		want  []checkpoint.State
+	}{
+		{
+			name: "good",
+			want: []checkpoint.State{
+				{Offset: 4, Chunks: 1},
+				{Offset: 12, Chunks: 2},
+				{Offset: 16, Chunks: 3},
+			},
+		},
+		{
+			name:  "resume",
+			start: checkpoint.State{Offset: 100, Chunks: 10},
+			want: []checkpoint.State{
+				{Offset: 104, Chunks: 11},
+				{Offset: 112, Chunks: 12},
+				{Offset: 116, Chunks: 13},
+			},
+		},
+		{
+			name: "save error",
+			err:  errSave,
+			want: []checkpoint.State{
+				{Offset: 4, Chunks: 1},
+			},
+		},
+	}
+	for _, tt := range testdata {
+		cp := &checkpointer{err: tt.err}
+		r := New(Options{
+			Reader:       strings.NewReader(input),
+			Throttler:    dummy.New(new(appendWriter)),
+			SplitFunc:    split.ByRE(regexp.MustCompile("\n")),
+			Checkpointer: cp,
+			Start:        tt.start,
+		})
+		if err := r.Run(); !errors.Is(err, tt.err) {
+			t.Errorf("Run(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(cp.s, tt.want); diff != "" {
+			t.Errorf("Run(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestPause(t *testing.T) {
+	w := new(appendWriter)
+	r := newRunner(strings.NewReader("foo\nbar\n"), w)
+	r.SetWaitDuration(0)
+	r.Pause()
+	errc := make(chan error)
+	go func() {
+		errc <- r.Run()
+	}()
+	select {
+	case err := <-errc:
+		t.Fatalf("Run() finished while paused, error = %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	r.Resume()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	if diff := pretty.Compare(w.s, []string{"foo\n", "bar\n"}); diff != "" {
+		t.Errorf("Run() -got +want:\n%v", diff)
+	}
+}
+
+func TestStep(t *testing.T) {
+	w := new(appendWriter)
+	r := newRunner(strings.NewReader("foo\nbar\nbaz\nquux\n"), w)
+	r.Pause()
+	r.Skip(1)
+	errc := make(chan error)
+	go func() {
+		errc <- r.Run()
+	}()
+	r.Step()
+	r.Step()
+	time.Sleep(50 * time.Millisecond)
+	if got := r.Status(); got.Chunks != 2 || got.Skipped != 1 || got.Offset != 12 || !got.Paused || got.Draining {
+		t.Errorf("Status() = %+v", got)
+	}
+	r.Drain()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	if diff := pretty.Compare(w.s, []string{"bar\n", "baz\n"}); diff != "" {
+		t.Errorf("Run() -got +want:\n%v", diff)
+	}
+	if got := r.Status(); got.Chunks != 2 || got.Offset != 12 || !got.Draining {
+		t.Errorf("Status() = %+v", got)
+	}
+}
+
+func TestSetInput(t *testing.T) {
+	s := stats.New(0, 0)
+	r := New(Options{Reader: strings.NewReader(""), Throttler: dummy.New(new(appendWriter)), Stats: s})
+	r.SetInput("b.log", 2, 3)
+	if got := r.Status(); got.Input != "b.log" || got.InputNum != 2 || got.Inputs != 3 {
+		t.Errorf("Status() = %+v", got)
+	}
+	if got := s.Snapshot(); got.Input != "b.log" || got.InputNum != 2 || got.Inputs != 3 {
+		t.Errorf("Snapshot() = %+v", got)
+	}
+}
+
+func TestDrain_blockedInput(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r := newRunner(pr, new(appendWriter))
+	errc := make(chan error)
+	go func() {
+		errc <- r.Run()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.Drain()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestRun_stats(t *testing.T) {
+	input := "foo\nbar baz\nquux"
+	st := stats.New(int64(len(input)), 0)
+	r := New(Options{
+		Reader:       strings.NewReader(input),
+		Throttler:    dummy.New(new(appendWriter)),
+		SplitFunc:    split.ByRE(regexp.MustCompile("\n")),
+		WaitDuration: time.Millisecond,
+		Stats:        st,
+	})
+	if err := r.Run(); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+	got := st.Snapshot()
+	if got.Chunks != 3 || got.Bytes != 16 || got.Offset != 16 || got.Errors != 0 || got.Sleep < 3*time.Millisecond {
+		t.Errorf("Snapshot() = %+v", got)
+	}
+	if done, ok := got.Done(); !ok || done != 1 {
+		t.Errorf("Done() = %v, %v", done, ok)
+	}
+
+	st = stats.New(0, 0)
+	w := &appendWriter{err: errWrite}
+	r = newRunner(strings.NewReader(input), w)
+	r.stats = st
+	if err := r.Run(); !errors.Is(err, errWrite) {
+		t.Errorf("Run() error = %v, want %v", err, errWrite)
+	}
+	if got := st.Snapshot(); got.Errors != 1 || got.Chunks != 0 {
+		t.Errorf("Snapshot() = %+v", got)
+	}
+}
+
+// rejectThrottler rejects every chunk of data containing "a".
+type rejectThrottler struct {
+	*dummy.Dummy
+	last []byte
+}
+
+func (r *rejectThrottler) verdict() error {
+	if bytes.Contains(r.last, []byte("a")) {
+		return &throttler.Rejection{Chunk: r.last, Reason: "bad " + strings.TrimSpace(string(r.last))}
+	}
+	return nil
+}
+
+func (r *rejectThrottler) Wait() error {
+	return r.verdict()
+}
+
+func (r *rejectThrottler) Stop() error {
+	return r.verdict()
+}
+
+func (r *rejectThrottler) Write(b []byte) (int, error) {
+	r.last = b
+	return r.Dummy.Write(b)
+}
+
+func TestRun_deadLetter(t *testing.T) {
+	input := "foo\nbar baz\nquux\nbar"
+	testdata := []struct {
+		name       string
+		deadLetter bool
+		want       string
+		err        error
+	}{
+		{
+			name:       "dead letter",
+			deadLetter: true,
+			want:       `{"chunk":"bar baz\n","reason":"bad bar baz"}` + "\n" + `{"chunk":"bar","reason":"bad bar"}` + "\n",
+		},
+		{
+			name: "no dead letter",
+			err:  throttler.ErrRejected,
+		},
+	}
+	for _, tt := range testdata {
+		st := stats.New(0, 0)
+		cp := new(checkpointer)
+		opts := Options{
+			Reader:       strings.NewReader(input),
+			Throttler:    &rejectThrottler{Dummy: dummy.New(new(appendWriter))},
+			SplitFunc:    split.ByRE(regexp.MustCompile("\n")),
+			Checkpointer: cp,
+			Stats:        st,
+		}
+		dl := new(strings.Builder)
+		if tt.deadLetter {
+			opts.DeadLetter = dl
+		}
+		if err := New(opts).Run(); !errors.Is(err, tt.err) {
+			t.Errorf("Run(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if got := dl.String(); got != tt.want {
+			t.Errorf("DeadLetter(%v) = %q, want %q", tt.name, got, tt.want)
+		}
+		if tt.deadLetter {
+			// Rejected chunks are still acknowledged.
+			if got, want := cp.s[len(cp.s)-1], (checkpoint.State{Offset: int64(len(input)), Chunks: 4}); got != want {
+				t.Errorf("Checkpoint(%v) = %+v, want %+v", tt.name, got, want)
+			}
+			if got := st.Snapshot().Rejected; got != 2 {
+				t.Errorf("Rejected(%v) = %v, want 2", tt.name, got)
+			}
+		}
+	}
+}
+
+type inputs struct {
+	r   []io.Reader
+	err error
+}
+
+func (in *inputs) Next() (io.Reader, error) {
+	if len(in.r) == 0 {
+		if in.err != nil {
+			return nil, in.err
+		}
+		return nil, io.EOF
+	}
+	r := in.r[0]
+	in.r = in.r[1:]
+	return r, nil
+}
+
+func TestRun_inputs(t *testing.T) {
+	testdata := []struct {
+		name   string
+		inputs []string
+		err    error
+		want   []string
+		offset int64
+	}{
+		{
+			name:   "single",
+			inputs: []string{"foo\nbar"},
+			want:   []string{"foo\n", "bar"},
+			offset: 7,
+		},
+		{
+			name:   "unterminated chunks end at file boundaries",
+			inputs: []string{"foo\nbar", "baz\nquux\n", "", "blah"},
+			want:   []string{"foo\n", "bar", "baz\n", "quux\n", "blah"},
+			offset: 20,
+		},
+		{
+			name:   "next error",
+			inputs: []string{"foo\n"},
+			err:    errRead,
+		},
+	}
+	for _, tt := range testdata {
+		in := &inputs{err: tt.err}
+		for _, s := range tt.inputs {
+			in.r = append(in.r, strings.NewReader(s))
+		}
+		w := new(appendWriter)
+		cp := new(checkpointer)
+		opts := Options{
+			Inputs:       in,
+			Throttler:    dummy.New(w),
+			SplitFunc:    split.ByRE(regexp.MustCompile("\n")),
+			Checkpointer: cp,
+		}
+		if err := New(opts).Run(); err != tt.err {
+			t.Errorf("Run(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if tt.err != nil {
+			continue
+		}
+		if diff := pretty.Compare(w.s, tt.want); diff != "" {
+			t.Errorf("Run(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+		if got := cp.s[len(cp.s)-1].Offset; got != tt.offset {
+			t.Errorf("Offset(%v) = %v, want %v", tt.name, got, tt.offset)
+		}
+	}
+}
+
+func TestRun_header(t *testing.T) {
+	testdata := []struct {
+		name   string
+		each   bool
+		want   []string
+		header string
+	}{
+		{
+			name:   "throttler writes header",
+			want:   []string{"1,2\n", "3,4\n", "5,6\n"},
+			header: "x,y\n",
+		},
+		{
+			name:   "header on each chunk",
+			each:   true,
+			want:   []string{"a,b\n1,2\n", "a,b\n3,4\n", "x,y\n5,6\n"},
+			header: "x,y\n",
+		},
+	}
+	for _, tt := range testdata {
+		in := &inputs{r: []io.Reader{
+			strings.NewReader("a,b\n1,2\n3,4\n"),
+			strings.NewReader("x,y\n5,6\n"),
+		}}
+		w := new(appendWriter)
+		h := new(throttler.Header)
+		opts := Options{
+			Inputs:     in,
+			Throttler:  dummy.New(w),
+			SplitFunc:  split.ByRE(regexp.MustCompile("\n")),
+			Header:     h,
+			HeaderEach: tt.each,
+		}
+		if err := New(opts).Run(); err != nil {
+			t.Errorf("Run(%v) error = %v", tt.name, err)
+		}
+		if diff := pretty.Compare(w.s, tt.want); diff != "" {
+			t.Errorf("Run(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+		if got := string(h.Get()); got != tt.header {
+			t.Errorf("Header(%v) = %q, want %q", tt.name, got, tt.header)
+		}
+	}
+}
+
+func TestRun_oversize(t *testing.T) {
+	testdata := []struct {
+		name    string
+		max     int
+		policy  split.OversizePolicy
+		want    []string
+		rejects string
+		err     error
+	}{
+		{
+			name:   "default",
+			policy: split.OversizeError,
+			want:   []string{"foo\n", strings.Repeat("x", 100) + "\n", "bar\n"},
+		},
+		{
+			name:   "error",
+			max:    8,
+			policy: split.OversizeError,
+			err:    split.ErrTokenTooLarge,
+		},
+		{
+			name:   "truncate",
+			max:    64,
+			policy: split.OversizeTruncate,
+			want:   []string{"foo\n", strings.Repeat("x", 64) + "\n", "bar\n"},
+		},
+		{
+			name:   "split",
+			max:    64,
+			policy: split.OversizeSplit,
+			want:   []string{"foo\n", strings.Repeat("x", 64), strings.Repeat("x", 36) + "\n", "bar\n"},
+		},
+		{
+			name:    "skip",
+			max:     64,
+			policy:  split.OversizeSkip,
+			want:    []string{"foo\n", "bar\n"},
+			rejects: strings.Repeat("x", 100) + "\n",
+		},
+	}
+	for _, tt := range testdata {
+		w := new(appendWriter)
+		cp := new(checkpointer)
+		var rejects strings.Builder
+		re := regexp.MustCompile("\n")
+		opts := Options{
+			Reader:       strings.NewReader("foo\n" + strings.Repeat("x", 100) + "\nbar\n"),
+			Throttler:    dummy.New(w),
+			SplitFunc:    split.ByRE(re),
+			Checkpointer: cp,
+			MaxChunkSize: tt.max,
+			Oversize:     tt.policy,
+			Delimiter:    split.REDelimiter(re),
+			Rejects:      &rejects,
+		}
+		if err := New(opts).Run(); !errors.Is(err, tt.err) {
+			t.Errorf("Run(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if tt.err != nil {
+			continue
+		}
+		if diff := pretty.Compare(w.s, tt.want); diff != "" {
+			t.Errorf("Run(%v) diff (-got +want):\n%v", tt.name, diff)
+		}
+		if got := rejects.String(); got != tt.rejects {
+			t.Errorf("Run(%v) rejects = %q, want %q", tt.name, got, tt.rejects)
+		}
+		// Skipped input still counts as consumed.
+		if got := cp.s[len(cp.s)-1].Offset; got != 109 {
+			t.Errorf("Offset(%v) = %v, want 109", tt.name, got)
+		}
+	}
+}
+
+func TestRun_expectInputError(t *testing.T) {
+	e, err := expect.New(expect.Options{
+		Command:   []string{"sh", "-c", "echo ready; cat"},
+		SplitFunc: split.ByRE(regexp.MustCompile("\n")),
+	})
+	if err != nil {
+		t.Fatalf("expect.New() error = %v", err)
+	}
+	opts := Options{
+		Reader:    io.MultiReader(strings.NewReader("foo\nbar\n"), badReader{}),
+		Throttler: e,
+		SplitFunc: split.ByRE(regexp.MustCompile("\n")),
+	}
+	errc := make(chan error)
+	go func() {
+		errc <- New(opts).Run()
+	}()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, errRead) {
+			t.Errorf("Run() error = %v, want %v", err, errRead)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() timeout")
+	}
+}
+
+func TestRun_compressed(t *testing.T) {
+	var in bytes.Buffer
+	zw := gzip.NewWriter(&in)
+	var want []string
+	for i := 0; i < 1000; i++ {
+		line := fmt.Sprintf("line %v\n", i)
+		want = append(want, line)
+		zw.Write([]byte(line))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w := new(appendWriter)
+	cp := new(checkpointer)
+	opts := Options{
+		Reader:       input.NewReader(&in, input.Auto),
+		Throttler:    dummy.New(w),
+		SplitFunc:    split.ByRE(regexp.MustCompile("\n")),
+		Checkpointer: cp,
+	}
+	if err := New(opts).Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if diff := pretty.Compare(w.s, want); diff != "" {
+		t.Errorf("Run() diff (-got +want):\n%v", diff)
+	}
+	last := cp.s[len(cp.s)-1]
+	if got, want := last, (checkpoint.State{Chunks: 1000, Offset: int64(len(strings.Join(want, "")))}); got != want {
+		t.Errorf("Checkpoint = %+v, want %+v", got, want)
+	}
+}
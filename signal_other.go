@@ -0,0 +1,7 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+// handleSignals is a no-op on platforms without SIGUSR1/SIGUSR2/SIGHUP.
+func handleSignals(*pipeline) {}
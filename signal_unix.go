@@ -0,0 +1,44 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals adjusts a running pipeline on signals:
+// SIGUSR1 pauses output, SIGUSR2 resumes it, SIGHUP reloads --config (if set).
+// SIGINT, SIGTERM and SIGQUIT kill wrapped commands running in their own process groups
+// before this process is terminated by the same signal, they'd otherwise be left running.
+func handleSignals(p *pipeline) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	if *configFile != "" {
+		signal.Notify(c, syscall.SIGHUP)
+	}
+	if p.cmds != nil {
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	}
+	go func() {
+		for sig := range c {
+			switch sig {
+			case syscall.SIGUSR1:
+				p.Pause()
+			case syscall.SIGUSR2:
+				p.Resume()
+			case syscall.SIGHUP:
+				if err := p.reload(*configFile); err != nil {
+					fmt.Fprintf(os.Stderr, "unable to reload %v: %v\n", *configFile, err)
+				}
+			case syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
+				p.cmds.KillGroup()
+				signal.Reset(sig)
+				syscall.Kill(syscall.Getpid(), sig.(syscall.Signal))
+			}
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+package split
+
+import (
+	"bufio"
+	"errors"
+)
+
+// ErrUnterminatedQuote is returned when the input ends in the middle of a quoted CSV field.
+var ErrUnterminatedQuote = errors.New("unterminated quoted field")
+
+// ByCSVRecord is a closure for a SplitFunc that splits on CSV records as per RFC 4180,
+// fields are separated by comma and may be quoted by quote, e.g., ',' and '"'.
+// Newlines and commas inside quoted fields don't end a record or field, a quote inside a quoted field is escaped by doubling it.
+// Quotes inside fields that don't start with a quote are kept as is.
+// Returns all remaining data up to (and including) the newline ending a record.
+func ByCSVRecord(comma, quote byte) bufio.SplitFunc {
+	// scan is the search for the newline ending the current record and seen is the length of the data it refers to.
+	// The data only grows between calls until the scanner is advanced, the search starts over otherwise.
+	var scan csvScan
+	seen := 0
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if len(data) <= seen {
+			scan = csvScan{}
+		}
+		seen = len(data)
+		if end := scan.end(data, comma, quote); end >= 0 {
+			scan, seen = csvScan{}, 0
+			return end, data[0:end], nil
+		}
+		if !atEOF {
+			return 0, nil, nil
+		}
+		if scan.inQuote {
+			return 0, nil, ErrUnterminatedQuote
+		}
+		scan, seen = csvScan{}, 0
+		return len(data), data, nil
+	}
+}
+
+// A csvScan is a search for the end of a CSV record, it's resumed where it left off as more data is read.
+type csvScan struct {
+	i int
+	// fieldStart indicates whether data[i] starts a field, quoted whether the current field started with a quote,
+	// inQuote whether the last quote in it opened (as opposed to closed) quoting.
+	fieldStart, quoted, inQuote bool
+}
+
+// end returns the index right after the newline ending the record at the start of data,
+// -1 if more data is needed to find the end of the record.
+func (s *csvScan) end(data []byte, comma, quote byte) int {
+	if s.i == 0 {
+		s.fieldStart = true
+	}
+	for ; s.i < len(data); s.i++ {
+		c := data[s.i]
+		switch {
+		case s.inQuote:
+			if c == quote {
+				s.inQuote = false
+			}
+			continue
+		case c == quote && (s.fieldStart || s.quoted):
+			// Opening quote, or the second half of an escaped quote.
+			s.quoted, s.inQuote = true, true
+		case c == comma:
+			s.fieldStart, s.quoted = true, false
+			continue
+		case c == '\n':
+			return s.i + 1
+		}
+		s.fieldStart = false
+	}
+	return -1
+}
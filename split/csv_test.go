@@ -0,0 +1,107 @@
+package split
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestByCSVRecord(t *testing.T) {
+	testdata := []struct {
+		name  string
+		input string
+		comma byte
+		quote byte
+		want  []string
+		err   error
+	}{
+		{
+			name:  "simple",
+			input: "a,b,c\n1,2,3\r\n4,5,6",
+			want:  []string{"a,b,c\n", "1,2,3\r\n", "4,5,6"},
+		},
+		{
+			name:  "quoted newlines",
+			input: "name,address\n\"Jane\",\"1 Main St\nSpringfield\"\n\"Joe\",\"\"\n",
+			want:  []string{"name,address\n", "\"Jane\",\"1 Main St\nSpringfield\"\n", "\"Joe\",\"\"\n"},
+		},
+		{
+			name:  "escaped quotes",
+			input: "\"say \"\"hi\nthere\"\"\",x\ny\n",
+			want:  []string{"\"say \"\"hi\nthere\"\"\",x\n", "y\n"},
+		},
+		{
+			name:  "quotes inside unquoted fields",
+			input: "5\" disk,\"ok\"\nnext\n",
+			want:  []string{"5\" disk,\"ok\"\n", "next\n"},
+		},
+		{
+			name:  "custom delimiter and quote",
+			input: "'a\nb';'c;d'\n'x'\n",
+			comma: ';',
+			quote: '\'',
+			want:  []string{"'a\nb';'c;d'\n", "'x'\n"},
+		},
+		{
+			name:  "double quotes are plain with a custom quote",
+			input: "\"a\nb\"\n",
+			comma: ';',
+			quote: '\'',
+			want:  []string{"\"a\n", "b\"\n"},
+		},
+		{
+			name:  "empty lines",
+			input: "a\n\nb\n",
+			want:  []string{"a\n", "\n", "b\n"},
+		},
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "unterminated quote",
+			input: "a\n\"b\nc",
+			want:  []string{"a\n"},
+			err:   ErrUnterminatedQuote,
+		},
+	}
+	for _, tt := range testdata {
+		if tt.comma == 0 {
+			tt.comma, tt.quote = ',', '"'
+		}
+		s := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(tt.input)))
+		s.Split(ByCSVRecord(tt.comma, tt.quote))
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if err := s.Err(); !errors.Is(err, tt.err) {
+			t.Errorf("ByCSVRecord(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("ByCSVRecord(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestByCSVRecord_large(t *testing.T) {
+	// The search for the end of a record resumes where it left off, reading it a byte at a time stays linear.
+	want := `a,"` + strings.Repeat("x\n\"\",", 40000) + "\"\n"
+	s := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(want + want)))
+	s.Buffer(nil, 2*len(want))
+	s.Split(ByCSVRecord(',', '"'))
+	var got []string
+	for s.Scan() {
+		got = append(got, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("ByCSVRecord() error = %v", err)
+	}
+	if diff := pretty.Compare(got, []string{want, want}); diff != "" {
+		t.Errorf("ByCSVRecord() -got +want:\n%v", diff)
+	}
+}
@@ -0,0 +1,64 @@
+package split
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+)
+
+// ErrEmptyDelimiter is returned when splitting on an empty delimiter.
+var ErrEmptyDelimiter = errors.New("empty delimiter")
+
+// ByDelimiter is a closure for a SplitFunc that splits on any of a set of literal delimiters,
+// it's a faster alternative to ByRE when there's no need for a regular expression.
+// The earliest delimiter in the data wins, the longest one if several start at the same position.
+// Returns all remaining data up to (and including) the delimiter.
+func ByDelimiter(delims ...[]byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		start, n := -1, 0
+		for _, d := range delims {
+			if len(d) == 0 {
+				return 0, nil, ErrEmptyDelimiter
+			}
+			limit := len(data)
+			if start >= 0 {
+				// Only earlier (or longer) matches can win.
+				limit = start + len(d)
+				if limit > len(data) {
+					limit = len(data)
+				}
+			}
+			i := index(data[:limit], d)
+			if i >= 0 && (start < 0 || i < start || (i == start && len(d) > n)) {
+				start, n = i, len(d)
+			}
+		}
+		if start < 0 {
+			if atEOF {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		}
+		if !atEOF {
+			// A longer delimiter may start at the same position, e.g., "\r\n" after "\r".
+			for _, d := range delims {
+				if len(d) > n && len(data)-start < len(d) && bytes.HasPrefix(d, data[start:]) {
+					return 0, nil, nil
+				}
+			}
+		}
+		end := start + n
+		return end, data[0:end], nil
+	}
+}
+
+// index returns the index of the first instance of a delimiter in data, -1 if it's not present.
+func index(data, d []byte) int {
+	if len(d) == 1 {
+		return bytes.IndexByte(data, d[0])
+	}
+	return bytes.Index(data, d)
+}
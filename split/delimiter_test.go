@@ -0,0 +1,72 @@
+package split
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestByDelimiter(t *testing.T) {
+	testdata := []struct {
+		name   string
+		delims []string
+		input  string
+		want   []string
+		err    error
+	}{
+		{
+			name:   "null",
+			delims: []string{"\x00"},
+			input:  "./a b\x00./c\nd\x00./e",
+			want:   []string{"./a b\x00", "./c\nd\x00", "./e"},
+		},
+		{
+			name:   "multi-byte",
+			delims: []string{"--"},
+			input:  "a-b--c---d",
+			want:   []string{"a-b--", "c--", "-d"},
+		},
+		{
+			name:   "alternatives",
+			delims: []string{";", "\n"},
+			input:  "a;b\nc;",
+			want:   []string{"a;", "b\n", "c;"},
+		},
+		{
+			name:   "longest at the same position",
+			delims: []string{"\r", "\r\n", "\n"},
+			input:  "a\r\nb\rc\nd\r",
+			want:   []string{"a\r\n", "b\r", "c\n", "d\r"},
+		},
+		{
+			name:   "earliest wins",
+			delims: []string{"world", "o"},
+			input:  "hello world",
+			want:   []string{"hello", " wo", "rld"},
+		},
+		{
+			name:   "empty",
+			delims: []string{"\x00"},
+		},
+		{
+			name:   "empty delimiter",
+			delims: []string{""},
+			input:  "foo",
+			err:    ErrEmptyDelimiter,
+		},
+	}
+	for _, tt := range testdata {
+		var delims [][]byte
+		for _, d := range tt.delims {
+			delims = append(delims, []byte(d))
+		}
+		got, err := scanAll([]byte(tt.input), ByDelimiter(delims...))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ByDelimiter(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("ByDelimiter(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package split
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	// ErrBadPrefixSize is returned for a length prefix size other than 1, 2, 4 or 8 bytes.
+	ErrBadPrefixSize = errors.New("length prefix size must be 1, 2, 4 or 8 bytes")
+
+	// ErrBadLength is returned when a frame's length prefix can't be decoded or is shorter than the prefix itself.
+	ErrBadLength = errors.New("invalid frame length")
+
+	// ErrFrameTooLarge is returned when a frame exceeds the maximum frame size.
+	ErrFrameTooLarge = errors.New("frame too large")
+
+	// ErrTruncatedFrame is returned when the input ends in the middle of a frame.
+	ErrTruncatedFrame = errors.New("truncated frame")
+)
+
+// A LengthPrefix describes a fixed-width length prefix for ByLengthPrefix.
+type LengthPrefix struct {
+	// Size is the size of the length prefix in bytes, either 1, 2, 4 or 8.
+	Size int
+
+	// Order is the length prefix's byte order, e.g., binary.BigEndian.
+	Order binary.ByteOrder
+
+	// Inclusive indicates whether the length counts the length prefix itself, as opposed to just the payload.
+	Inclusive bool
+
+	// Max is the maximum frame size (including the length prefix), unlimited if <= 0.
+	Max int
+}
+
+// length decodes the length prefix at the start of data.
+func (p LengthPrefix) length(data []byte) uint64 {
+	switch p.Size {
+	case 1:
+		return uint64(data[0])
+	case 2:
+		return uint64(p.Order.Uint16(data))
+	case 4:
+		return uint64(p.Order.Uint32(data))
+	}
+	return p.Order.Uint64(data)
+}
+
+// ByLengthPrefix is a closure for a SplitFunc that splits on frames starting with a fixed-width length prefix.
+// Returns one complete frame at a time, including its length prefix.
+func ByLengthPrefix(p LengthPrefix) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if p.Size != 1 && p.Size != 2 && p.Size != 4 && p.Size != 8 {
+			return 0, nil, ErrBadPrefixSize
+		}
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if len(data) < p.Size {
+			return needFrame(atEOF)
+		}
+		n := p.length(data)
+		switch {
+		case !p.Inclusive && n > math.MaxUint64-uint64(p.Size):
+			return 0, nil, fmt.Errorf("%w: %v", ErrFrameTooLarge, n)
+		case !p.Inclusive:
+			n += uint64(p.Size)
+		case n < uint64(p.Size):
+			return 0, nil, fmt.Errorf("%w: %v is shorter than the %v-byte prefix", ErrBadLength, n, p.Size)
+		}
+		return frame(data, n, p.Max, atEOF)
+	}
+}
+
+// ByUvarint is a closure for a SplitFunc that splits on frames starting with a uvarint length prefix,
+// e.g., delimited protobuf messages, frames are at most max bytes long (including the length prefix) if max > 0.
+// Returns one complete frame at a time, including its length prefix.
+func ByUvarint(max int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		n, size := binary.Uvarint(data)
+		switch {
+		case size == 0:
+			return needFrame(atEOF)
+		case size < 0:
+			return 0, nil, fmt.Errorf("%w: uvarint overflow", ErrBadLength)
+		}
+		if n > math.MaxUint64-uint64(size) {
+			return 0, nil, fmt.Errorf("%w: %v", ErrFrameTooLarge, n)
+		}
+		return frame(data, n+uint64(size), max, atEOF)
+	}
+}
+
+// frame returns a frame of n bytes (including its length prefix) at the start of data.
+func frame(data []byte, n uint64, max int, atEOF bool) (advance int, token []byte, err error) {
+	if (max > 0 && n > uint64(max)) || n > math.MaxInt32 {
+		return 0, nil, fmt.Errorf("%w: %v bytes", ErrFrameTooLarge, n)
+	}
+	if uint64(len(data)) < n {
+		return needFrame(atEOF)
+	}
+	return int(n), data[0:n], nil
+}
+
+// needFrame asks for more data to complete a frame, unless there's none left.
+func needFrame(atEOF bool) (advance int, token []byte, err error) {
+	if atEOF {
+		return 0, nil, ErrTruncatedFrame
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,172 @@
+package split
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"testing/iotest"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// scanAll splits input one byte at a time, returns all tokens.
+func scanAll(input []byte, f bufio.SplitFunc) ([]string, error) {
+	s := bufio.NewScanner(iotest.OneByteReader(bytes.NewReader(input)))
+	s.Split(f)
+	var got []string
+	for s.Scan() {
+		got = append(got, s.Text())
+	}
+	return got, s.Err()
+}
+
+func TestByLengthPrefix(t *testing.T) {
+	testdata := []struct {
+		name   string
+		prefix LengthPrefix
+		input  string
+		want   []string
+		err    error
+	}{
+		{
+			name:   "1 byte",
+			prefix: LengthPrefix{Size: 1},
+			input:  "\x03foo\x00\x02ba",
+			want:   []string{"\x03foo", "\x00", "\x02ba"},
+		},
+		{
+			name:   "2 bytes big endian",
+			prefix: LengthPrefix{Size: 2, Order: binary.BigEndian},
+			input:  "\x00\x03foo\x00\x01x",
+			want:   []string{"\x00\x03foo", "\x00\x01x"},
+		},
+		{
+			name:   "4 bytes little endian",
+			prefix: LengthPrefix{Size: 4, Order: binary.LittleEndian},
+			input:  "\x03\x00\x00\x00foo\x01\x00\x00\x00x",
+			want:   []string{"\x03\x00\x00\x00foo", "\x01\x00\x00\x00x"},
+		},
+		{
+			name:   "8 bytes big endian",
+			prefix: LengthPrefix{Size: 8, Order: binary.BigEndian},
+			input:  "\x00\x00\x00\x00\x00\x00\x00\x03foo",
+			want:   []string{"\x00\x00\x00\x00\x00\x00\x00\x03foo"},
+		},
+		{
+			name:   "inclusive",
+			prefix: LengthPrefix{Size: 2, Order: binary.BigEndian, Inclusive: true},
+			input:  "\x00\x05foo\x00\x02",
+			want:   []string{"\x00\x05foo", "\x00\x02"},
+		},
+		{
+			name:   "inclusive too short",
+			prefix: LengthPrefix{Size: 2, Order: binary.BigEndian, Inclusive: true},
+			input:  "\x00\x01foo",
+			err:    ErrBadLength,
+		},
+		{
+			name:   "max",
+			prefix: LengthPrefix{Size: 1, Max: 4},
+			input:  "\x03foo\x04quux",
+			want:   []string{"\x03foo"},
+			err:    ErrFrameTooLarge,
+		},
+		{
+			name:   "huge",
+			prefix: LengthPrefix{Size: 8, Order: binary.BigEndian},
+			input:  "\xff\xff\xff\xff\xff\xff\xff\xff",
+			err:    ErrFrameTooLarge,
+		},
+		{
+			name:   "truncated payload",
+			prefix: LengthPrefix{Size: 1},
+			input:  "\x03foo\x03ba",
+			want:   []string{"\x03foo"},
+			err:    ErrTruncatedFrame,
+		},
+		{
+			name:   "truncated prefix",
+			prefix: LengthPrefix{Size: 4, Order: binary.BigEndian},
+			input:  "\x00\x00",
+			err:    ErrTruncatedFrame,
+		},
+		{
+			name:   "empty",
+			prefix: LengthPrefix{Size: 4, Order: binary.BigEndian},
+		},
+		{
+			name:   "bad size",
+			prefix: LengthPrefix{Size: 3, Order: binary.BigEndian},
+			input:  "\x00\x00\x00",
+			err:    ErrBadPrefixSize,
+		},
+	}
+	for _, tt := range testdata {
+		got, err := scanAll([]byte(tt.input), ByLengthPrefix(tt.prefix))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ByLengthPrefix(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("ByLengthPrefix(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestByUvarint(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 300)
+	testdata := []struct {
+		name  string
+		input []byte
+		max   int
+		want  []string
+		err   error
+	}{
+		{
+			name:  "frames",
+			input: append([]byte("\x03foo\x00\xac\x02"), long...),
+			want:  []string{"\x03foo", "\x00", "\xac\x02" + string(long)},
+		},
+		{
+			name:  "max",
+			input: append([]byte("\x03foo\xac\x02"), long...),
+			max:   100,
+			want:  []string{"\x03foo"},
+			err:   ErrFrameTooLarge,
+		},
+		{
+			name:  "truncated payload",
+			input: []byte("\x03fo"),
+			err:   ErrTruncatedFrame,
+		},
+		{
+			name:  "truncated varint",
+			input: []byte("\x03foo\xac"),
+			want:  []string{"\x03foo"},
+			err:   ErrTruncatedFrame,
+		},
+		{
+			name:  "overflow",
+			input: []byte("\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01"),
+			err:   ErrBadLength,
+		},
+		{
+			name:  "too large",
+			input: []byte("\xff\xff\xff\xff\xff\xff\xff\xff\x7f"),
+			err:   ErrFrameTooLarge,
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range testdata {
+		got, err := scanAll(tt.input, ByUvarint(tt.max))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ByUvarint(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("ByUvarint(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package split
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrBadJSON is returned when the input isn't a sequence of JSON values.
+	ErrBadJSON = errors.New("invalid JSON value")
+
+	// ErrIncompleteJSON is returned when the input ends in the middle of a JSON value.
+	ErrIncompleteJSON = errors.New("incomplete JSON value")
+)
+
+// ByJSON is a closure for a SplitFunc that splits on JSON values,
+// e.g., JSON Lines or concatenated (possibly pretty-printed) JSON documents.
+// Whitespace before a value is skipped, trailing spaces and tabs are kept in the token up to (and including) a newline.
+// Returns one complete JSON value at a time, or ErrBadJSON if a value isn't valid JSON.
+func ByJSON() bufio.SplitFunc {
+	// scan is the search for the end of the current value and seen is the length of the data it refers to.
+	// The data only grows between calls until the scanner is advanced, the search starts over otherwise.
+	var scan jsonScan
+	seen := 0
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if len(data) <= seen {
+			scan = jsonScan{}
+		}
+		seen = len(data)
+		if !scan.started {
+			scan.start = skipSpace(data, 0)
+		}
+		start := scan.start
+		if start == len(data) {
+			// Only whitespace so far, keep it around until the next value unless there's nothing else.
+			if atEOF {
+				scan, seen = jsonScan{}, 0
+				return len(data), nil, nil
+			}
+			return 0, nil, nil
+		}
+		end, err := scan.end(data, atEOF)
+		if err != nil {
+			return 0, nil, err
+		}
+		if end < 0 {
+			return 0, nil, nil
+		}
+		if !json.Valid(data[start:end]) {
+			return 0, nil, fmt.Errorf("%w: %.64q", ErrBadJSON, data[start:end])
+		}
+		for end < len(data) && (data[end] == ' ' || data[end] == '\t' || data[end] == '\r') {
+			end++
+		}
+		switch {
+		case end < len(data) && data[end] == '\n':
+			end++
+		case end == len(data) && !atEOF:
+			// The newline ending the value may be yet to come.
+			return 0, nil, nil
+		}
+		scan, seen = jsonScan{}, 0
+		return end, data[start:end], nil
+	}
+}
+
+// skipSpace returns the index of the first non-whitespace byte in data at or after i.
+func skipSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// A jsonScan is a search for the end of a JSON value, it's resumed where it left off as more data is read.
+// Only strings and nesting are tracked, the value itself is not validated.
+type jsonScan struct {
+	started  bool
+	start    int
+	i        int
+	found    int
+	stack    []byte
+	inString bool
+	escaped  bool
+}
+
+// end returns the index right after the JSON value starting at data[s.start],
+// -1 if more data is needed to find the end of the value.
+func (s *jsonScan) end(data []byte, atEOF bool) (int, error) {
+	if !s.started {
+		s.started = true
+		s.i = s.start
+	}
+	if s.found > 0 {
+		return s.found, nil
+	}
+	for ; s.i < len(data); s.i++ {
+		c := data[s.i]
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case c == '\\':
+				s.escaped = true
+			case c == '"':
+				s.inString = false
+				if len(s.stack) == 0 {
+					s.found = s.i + 1
+					return s.found, nil
+				}
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			s.inString = true
+		case '{':
+			s.stack = append(s.stack, '}')
+		case '[':
+			s.stack = append(s.stack, ']')
+		case '}', ']':
+			if len(s.stack) == 0 || s.stack[len(s.stack)-1] != c {
+				return 0, fmt.Errorf("%w: unexpected %q", ErrBadJSON, c)
+			}
+			s.stack = s.stack[:len(s.stack)-1]
+			if len(s.stack) == 0 {
+				s.found = s.i + 1
+				return s.found, nil
+			}
+		case ' ', '\t', '\r', '\n', ',', ':':
+			if len(s.stack) == 0 {
+				// End of a scalar.
+				s.found = s.i
+				return s.found, nil
+			}
+		}
+	}
+	switch {
+	case !atEOF:
+		return -1, nil
+	case len(s.stack) == 0 && !s.inString:
+		// A scalar at the end of the input.
+		return len(data), nil
+	}
+	return 0, ErrIncompleteJSON
+}
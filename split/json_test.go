@@ -0,0 +1,114 @@
+package split
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestByJSON(t *testing.T) {
+	testdata := []struct {
+		name  string
+		input string
+		want  []string
+		err   error
+	}{
+		{
+			name:  "json lines",
+			input: "{\"a\":1}\n{\"b\":[1,2]}\n",
+			want:  []string{"{\"a\":1}\n", "{\"b\":[1,2]}\n"},
+		},
+		{
+			name:  "pretty printed",
+			input: "{\n  \"a\": {\n    \"b\": [1, 2]\n  }\n}\n[\n  3\n]",
+			want:  []string{"{\n  \"a\": {\n    \"b\": [1, 2]\n  }\n}\n", "[\n  3\n]"},
+		},
+		{
+			name:  "strings with brackets and escapes",
+			input: `{"a":"}\"\\{\n"}` + "\r\n" + `"]"`,
+			want:  []string{`{"a":"}\"\\{\n"}` + "\r\n", `"]"`},
+		},
+		{
+			name:  "concatenated",
+			input: `{"a":1}{"b":2} [3]`,
+			want:  []string{`{"a":1}`, `{"b":2} `, `[3]`},
+		},
+		{
+			name:  "scalars",
+			input: "  1 true\t\"x\"\nnull -2.5e3",
+			want:  []string{"1 ", "true\t", "\"x\"\n", "null ", "-2.5e3"},
+		},
+		{
+			name:  "surrounding whitespace",
+			input: "\n\n  {}  \n\n",
+			want:  []string{"{}  \n"},
+		},
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "incomplete",
+			input: "{\"a\":1}\n{\"b\":",
+			want:  []string{"{\"a\":1}\n"},
+			err:   ErrIncompleteJSON,
+		},
+		{
+			name:  "unterminated string",
+			input: `"abc`,
+			err:   ErrIncompleteJSON,
+		},
+		{
+			name:  "mismatched brackets",
+			input: `{"a":[1}`,
+			err:   ErrBadJSON,
+		},
+		{
+			name:  "invalid value",
+			input: "{\"a\" 1}\n",
+			err:   ErrBadJSON,
+		},
+		{
+			name:  "invalid scalar",
+			input: "nope\n",
+			err:   ErrBadJSON,
+		},
+	}
+	for _, tt := range testdata {
+		// Reading one byte at a time exercises values spanning several reads.
+		s := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(tt.input)))
+		s.Split(ByJSON())
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if err := s.Err(); !errors.Is(err, tt.err) {
+			t.Errorf("ByJSON(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("ByJSON(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestByJSON_large(t *testing.T) {
+	// The search for the end of a value resumes where it left off, reading it a byte at a time stays linear.
+	want := "[" + strings.Repeat(`{"a":"x\"y"},`, 20000) + "1]\n"
+	s := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(want + want)))
+	s.Buffer(nil, 2*len(want))
+	s.Split(ByJSON())
+	var got []string
+	for s.Scan() {
+		got = append(got, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("ByJSON() error = %v", err)
+	}
+	if diff := pretty.Compare(got, []string{want, want}); diff != "" {
+		t.Errorf("ByJSON() -got +want:\n%v", diff)
+	}
+}
@@ -0,0 +1,194 @@
+package split
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrBadOversizePolicy is returned for an unknown oversize policy.
+	ErrBadOversizePolicy = errors.New("unknown oversize policy")
+
+	// ErrTokenTooLarge is returned for a token larger than the maximum size with OversizeError.
+	ErrTokenTooLarge = errors.New("token too large")
+)
+
+// OversizePolicy is what to do with tokens larger than the maximum size.
+type OversizePolicy int
+
+const (
+	// OversizeError aborts with ErrTokenTooLarge.
+	OversizeError OversizePolicy = iota
+
+	// OversizeTruncate truncates the token to the maximum size, the rest of it is discarded except for its delimiter.
+	OversizeTruncate
+
+	// OversizeSplit splits the token into tokens of up to the maximum size, the last one keeps its delimiter.
+	OversizeSplit
+
+	// OversizeSkip discards the whole token.
+	OversizeSkip
+)
+
+var oversizePolicyNames = map[OversizePolicy]string{
+	OversizeError:    "error",
+	OversizeTruncate: "truncate",
+	OversizeSplit:    "split",
+	OversizeSkip:     "skip",
+}
+
+// ParseOversizePolicy parses an oversize policy name.
+func ParseOversizePolicy(s string) (OversizePolicy, error) {
+	for p, name := range oversizePolicyNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("%q: %w", s, ErrBadOversizePolicy)
+}
+
+func (p OversizePolicy) String() string {
+	if name, ok := oversizePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OversizePolicy(%d)", int(p))
+}
+
+// WithMaxSize is a closure for a SplitFunc that handles tokens split by f that are larger than max bytes as per policy,
+// the input making up skipped tokens is written as is to rejects, if set.
+// The delimiter at the end of tokens is found by d, if set, truncated tokens keep theirs,
+// and a delimiter right after the last piece of a split token is kept with it rather than being a token of its own,
+// these may thus exceed max bytes by the length of the delimiter.
+// The scanner's maximum token size must be max, see bufio.Scanner.Buffer.
+// Except for OversizeError, f must be able to find the end of a token when called from the middle of it,
+// e.g., ByRE or ByDelimiter, the rest of an oversize token is then split by f as usual.
+func WithMaxSize(f bufio.SplitFunc, d Delimiter, max int, policy OversizePolicy, rejects io.Writer) bufio.SplitFunc {
+	// discarding indicates whether the rest of an oversize token is being discarded.
+	discarding := false
+	// held is the truncated start of an oversize token, or the last piece of one being split,
+	// it's held back until the delimiter is found.
+	var held []byte
+	// pos is how much of an oversize token being truncated has been consumed.
+	pos := 0
+	// next is the result of f held back while the last piece of a split token is returned.
+	var next *result
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if next != nil {
+			advance, token = next.advance, next.token
+			next = nil
+			return advance, token, nil
+		}
+		advance, token, err = f(data, atEOF)
+		if atEOF && len(data) == 0 {
+			// The end of the input, the next one starts with a new token.
+			discarding = false
+			if held != nil {
+				token, held = held, nil
+				return 0, token, err
+			}
+		}
+		if err != nil {
+			return advance, token, err
+		}
+		if discarding {
+			switch {
+			case token != nil:
+				// The rest of the oversize token, including its delimiter.
+				discarding = false
+				if policy == OversizeTruncate {
+					token, held = truncated(held, pos, token, d), nil
+					return advance, token, nil
+				}
+			case advance > 0:
+			case len(data) >= max:
+				// Keep the second half, it may hold the start of the delimiter.
+				advance = len(data) - len(data)/2
+			case atEOF:
+				advance = len(data)
+				discarding = false
+				if policy == OversizeTruncate {
+					token, held = held, nil
+					return advance, token, nil
+				}
+			default:
+				return 0, nil, nil
+			}
+			pos += advance
+			return advance, nil, reject(rejects, policy, data[:advance])
+		}
+		if held != nil {
+			// The last piece of a split token.
+			switch {
+			case token != nil && len(token) > 0 && delimiterLen(d, token) == len(token):
+				token, held = append(held, token...), nil
+				return advance, token, nil
+			case token != nil:
+				next = &result{advance, token}
+			case advance > 0:
+				return advance, nil, nil
+			case len(data) < max && !atEOF:
+				return 0, nil, nil
+			}
+			token, held = held, nil
+			return 0, token, nil
+		}
+		if token != nil || advance > 0 || len(data) < max {
+			return advance, token, nil
+		}
+		// The scanner's buffer is full but doesn't hold a whole token.
+		switch policy {
+		case OversizeError:
+			return 0, nil, fmt.Errorf("%w, the maximum is %d bytes", ErrTokenTooLarge, max)
+		case OversizeTruncate:
+			discarding = true
+			held = append([]byte(nil), data[:max]...)
+			// The second half is scanned again, it may hold the start of the delimiter.
+			pos = max - max/2
+			return pos, nil, nil
+		case OversizeSplit:
+			held = append([]byte(nil), data[:max]...)
+			return max, nil, nil
+		case OversizeSkip:
+			discarding = true
+			advance = max - max/2
+			return advance, nil, reject(rejects, policy, data[:advance])
+		}
+		return 0, nil, fmt.Errorf("%v: %w", policy, ErrBadOversizePolicy)
+	}
+}
+
+// A result is a SplitFunc's result.
+type result struct {
+	advance int
+	token   []byte
+}
+
+// truncated returns the truncated start of an oversize token followed by its delimiter,
+// rest is the rest of the token, pos bytes into it.
+func truncated(start []byte, pos int, rest []byte, d Delimiter) []byte {
+	n := delimiterLen(d, rest)
+	// The second half of the truncated start was scanned again, the delimiter may start within it.
+	if end := pos + len(rest) - n; end < len(start) {
+		start = start[:end]
+	}
+	return append(start, rest[len(rest)-n:]...)
+}
+
+// delimiterLen returns the length of the delimiter at the end of a token as found by d, 0 if d is nil.
+func delimiterLen(d Delimiter, token []byte) int {
+	if d == nil {
+		return 0
+	}
+	return d(token)
+}
+
+// reject writes the discarded input of a skipped token to rejects, if set.
+func reject(rejects io.Writer, policy OversizePolicy, b []byte) error {
+	if rejects == nil || policy != OversizeSkip {
+		return nil
+	}
+	_, err := rejects.Write(b)
+	return err
+}
@@ -0,0 +1,119 @@
+package split
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParseOversizePolicy(t *testing.T) {
+	for p, name := range oversizePolicyNames {
+		got, err := ParseOversizePolicy(name)
+		if err != nil {
+			t.Errorf("ParseOversizePolicy(%v) error = %v", name, err)
+		}
+		if got != p {
+			t.Errorf("ParseOversizePolicy(%v) = %v, want %v", name, got, p)
+		}
+	}
+	if _, err := ParseOversizePolicy("bogus"); !errors.Is(err, ErrBadOversizePolicy) {
+		t.Errorf("ParseOversizePolicy(bogus) error = %v, want %v", err, ErrBadOversizePolicy)
+	}
+}
+
+func TestWithMaxSize(t *testing.T) {
+	re := regexp.MustCompile(`\r\n`)
+	testdata := []struct {
+		name    string
+		policy  OversizePolicy
+		input   string
+		want    []string
+		rejects string
+		err     error
+	}{
+		{
+			name:   "fits",
+			policy: OversizeError,
+			input:  "ab\r\nabc",
+			want:   []string{"ab\r\n", "abc"},
+		},
+		{
+			name:   "error",
+			policy: OversizeError,
+			input:  "ab\r\nabcdefgh\r\nxy",
+			want:   []string{"ab\r\n"},
+			err:    ErrTokenTooLarge,
+		},
+		{
+			name:   "truncate",
+			policy: OversizeTruncate,
+			input:  "ab\r\nabcdefgh\r\nxy",
+			want:   []string{"ab\r\n", "abcd\r\n", "xy"},
+		},
+		{
+			name:   "truncate next",
+			policy: OversizeTruncate,
+			input:  "abcdefgh\r\nxy\r\nz",
+			want:   []string{"abcd\r\n", "xy\r\n", "z"},
+		},
+		{
+			name:   "truncate split delimiter",
+			policy: OversizeTruncate,
+			input:  "abc\r\nxy",
+			want:   []string{"abc\r\n", "xy"},
+		},
+		{
+			name:   "truncate last",
+			policy: OversizeTruncate,
+			input:  "abcdefgh",
+			want:   []string{"abcd"},
+		},
+		{
+			name:   "split",
+			policy: OversizeSplit,
+			input:  "ab\r\nabcdefgh\r\nxy",
+			want:   []string{"ab\r\n", "abcd", "efgh\r\n", "xy"},
+		},
+		{
+			name:   "split remainder",
+			policy: OversizeSplit,
+			input:  "abcdefghi\r\nxy",
+			want:   []string{"abcd", "efgh", "i\r\n", "xy"},
+		},
+		{
+			name:   "split last",
+			policy: OversizeSplit,
+			input:  "abcdefgh",
+			want:   []string{"abcd", "efgh"},
+		},
+		{
+			name:    "skip",
+			policy:  OversizeSkip,
+			input:   "ab\r\nabcdefgh\r\nxy\r\nabcde",
+			want:    []string{"ab\r\n", "xy\r\n"},
+			rejects: "abcdefgh\r\nabcde",
+		},
+		{
+			name:   "bad policy",
+			policy: OversizePolicy(-1),
+			input:  "abcdefgh",
+			err:    ErrBadOversizePolicy,
+		},
+	}
+	for _, tt := range testdata {
+		var rejects bytes.Buffer
+		got, err := scanAll([]byte(tt.input), WithMaxSize(ByRE(re), REDelimiter(re), 4, tt.policy, &rejects))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("WithMaxSize(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("WithMaxSize(%v) -got +want:\n%v", tt.name, diff)
+		}
+		if got := rejects.String(); got != tt.rejects {
+			t.Errorf("WithMaxSize(%v) rejects = %q, want %q", tt.name, got, tt.rejects)
+		}
+	}
+}
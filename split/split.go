@@ -3,6 +3,7 @@ package split
 
 import (
 	"bufio"
+	"bytes"
 	"regexp"
 )
 
@@ -23,6 +24,50 @@ func ByRE(re *regexp.Regexp) bufio.SplitFunc {
 	}
 }
 
+// ByRecordStart is a closure for a SplitFunc that splits on records spanning one or more lines.
+// A new record starts at every line matching a regular expression,
+// lines that don't match are continuation lines and are kept in the preceding record.
+// Returns all remaining data up to (but not including) the next record's first line.
+func ByRecordStart(re *regexp.Regexp) bufio.SplitFunc {
+	// line is where the first line not known to be part of the current record starts,
+	// searched is how far it's been searched for its end and seen is the length of the data they refer to.
+	// The data only grows between calls until the scanner is advanced, the search starts over otherwise.
+	line, searched, seen := 0, 0, 0
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if len(data) <= seen {
+			line, searched = 0, 0
+		}
+		seen = len(data)
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		for line < len(data) {
+			end := bytes.IndexByte(data[searched:], '\n')
+			if end < 0 {
+				if !atEOF {
+					// Need the whole line to decide whether it starts a new record.
+					searched = len(data)
+					return 0, nil, nil
+				}
+				end = len(data) - searched
+			}
+			end += searched
+			// The first line always belongs to the current record.
+			if line > 0 && re.Match(data[line:end]) {
+				advance = line
+				line, searched, seen = 0, 0, 0
+				return advance, data[0:advance], nil
+			}
+			line, searched = end+1, end+1
+		}
+		if atEOF {
+			line, searched, seen = 0, 0, 0
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
 // BySize is a closure for a SplitFunc that splits on a fixed byte size.
 func BySize(size int) bufio.SplitFunc {
 	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
@@ -78,3 +78,46 @@ func TestBySize(t *testing.T) {
 		}
 	}
 }
+
+func TestByRecordStart(t *testing.T) {
+	testdata := map[string][]string{
+		"2020-01-01 foo\n2020-01-02 bar\n": {
+			"2020-01-01 foo\n",
+			"2020-01-02 bar\n",
+		},
+		"2020-01-01 foo\n\tat bar\n\tat baz\n2020-01-02 quux": {
+			"2020-01-01 foo\n\tat bar\n\tat baz\n",
+			"2020-01-02 quux",
+		},
+		"leading garbage\n2020-01-01 foo\ncontinued": {
+			"leading garbage\n",
+			"2020-01-01 foo\ncontinued",
+		},
+		"no records\nat all\n": {
+			"no records\nat all\n",
+		},
+		"": nil,
+	}
+	for tt, want := range testdata {
+		s := bufio.NewScanner(strings.NewReader(tt))
+		s.Split(ByRecordStart(regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `)))
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if err := s.Err(); err != nil {
+			t.Errorf("s.Err() = %v", err)
+		}
+		if diff := pretty.Compare(got, want); diff != "" {
+			t.Errorf("ByRecordStart(%q) -got +want:\n%v", tt, diff)
+		}
+		// The search is picked up where it left off as more data is read.
+		got, err := scanAll([]byte(tt), ByRecordStart(regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `)))
+		if err != nil {
+			t.Errorf("ByRecordStart(%q) partial reads error = %v", tt, err)
+		}
+		if diff := pretty.Compare(got, want); diff != "" {
+			t.Errorf("ByRecordStart(%q) partial reads -got +want:\n%v", tt, diff)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package split
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrBadDelimiterMode is returned for an unknown delimiter mode.
+var ErrBadDelimiterMode = errors.New("unknown delimiter mode")
+
+// A Delimiter returns the length of the delimiter at the end of a token, 0 if there's none.
+type Delimiter func(token []byte) int
+
+// REDelimiter returns a Delimiter for tokens split by ByRE.
+func REDelimiter(re *regexp.Regexp) Delimiter {
+	return func(token []byte) int {
+		if loc := re.FindIndex(token); loc != nil && loc[1] == len(token) {
+			return loc[1] - loc[0]
+		}
+		return 0
+	}
+}
+
+// LiteralDelimiter returns a Delimiter for tokens split by ByDelimiter.
+func LiteralDelimiter(delims ...[]byte) Delimiter {
+	return func(token []byte) int {
+		n := 0
+		for _, d := range delims {
+			if len(d) > n && bytes.HasSuffix(token, d) {
+				n = len(d)
+			}
+		}
+		return n
+	}
+}
+
+// NewlineDelimiter is a Delimiter for tokens ending in a newline, e.g., as split by ByRecordStart, ByJSON or ByCSVRecord.
+func NewlineDelimiter(token []byte) int {
+	switch {
+	case bytes.HasSuffix(token, []byte("\r\n")):
+		return 2
+	case bytes.HasSuffix(token, []byte("\n")):
+		return 1
+	}
+	return 0
+}
+
+// DelimiterMode is what to do with the delimiter at the end of each token.
+type DelimiterMode int
+
+const (
+	// Keep keeps the delimiter at the end of the token.
+	Keep DelimiterMode = iota
+
+	// Strip removes the delimiter.
+	Strip
+
+	// Replace replaces the delimiter with a terminator, e.g., "\r\n" with "\n".
+	Replace
+
+	// Move moves the delimiter to the start of the next token, the last delimiter is dropped.
+	Move
+)
+
+var delimiterModeNames = map[DelimiterMode]string{
+	Keep:    "keep",
+	Strip:   "strip",
+	Replace: "replace",
+	Move:    "move",
+}
+
+// ParseDelimiterMode parses a delimiter mode name.
+func ParseDelimiterMode(s string) (DelimiterMode, error) {
+	for m, name := range delimiterModeNames {
+		if s == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("%q: %w", s, ErrBadDelimiterMode)
+}
+
+func (m DelimiterMode) String() string {
+	if name, ok := delimiterModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("DelimiterMode(%d)", int(m))
+}
+
+// WithDelimiter is a closure for a SplitFunc that handles the delimiter at the end of each token split by f as per mode,
+// the delimiter is found by d and replaced by term in Replace mode.
+// Tokens without a delimiter (e.g., the last one) are left alone, except for a preceding delimiter in Move mode.
+// The input consumed by each token is unchanged.
+func WithDelimiter(f bufio.SplitFunc, d Delimiter, mode DelimiterMode, term []byte) bufio.SplitFunc {
+	// carry is the delimiter moved to the next token in Move mode.
+	var carry []byte
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		advance, token, err = f(data, atEOF)
+		if atEOF && len(data) == 0 {
+			// The end of the input, don't carry anything over to the next one.
+			carry = nil
+		}
+		if token == nil || mode == Keep {
+			return advance, token, err
+		}
+		n := d(token)
+		body := token[:len(token)-n]
+		switch mode {
+		case Strip:
+			return advance, body, err
+		case Replace:
+			if n == 0 {
+				return advance, token, err
+			}
+			return advance, append(append([]byte(nil), body...), term...), err
+		case Move:
+			// Empty tokens must not be nil, the scanner would skip them.
+			b := append(append(make([]byte, 0, len(carry)+len(body)), carry...), body...)
+			carry = append(carry[:0], token[len(body):]...)
+			return advance, b, err
+		}
+		return 0, nil, fmt.Errorf("%v: %w", mode, ErrBadDelimiterMode)
+	}
+}
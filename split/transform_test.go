@@ -0,0 +1,117 @@
+package split
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParseDelimiterMode(t *testing.T) {
+	for _, m := range []DelimiterMode{Keep, Strip, Replace, Move} {
+		got, err := ParseDelimiterMode(m.String())
+		if err != nil {
+			t.Errorf("ParseDelimiterMode(%v) error = %v", m, err)
+		}
+		if got != m {
+			t.Errorf("ParseDelimiterMode(%v) = %v", m, got)
+		}
+	}
+	if _, err := ParseDelimiterMode("bogus"); !errors.Is(err, ErrBadDelimiterMode) {
+		t.Errorf("ParseDelimiterMode(bogus) error = %v, want %v", err, ErrBadDelimiterMode)
+	}
+}
+
+func TestDelimiters(t *testing.T) {
+	testdata := []struct {
+		name  string
+		d     Delimiter
+		token string
+		want  int
+	}{
+		{"re", REDelimiter(regexp.MustCompile(`\r?\n`)), "foo\r\n", 2},
+		{"re without delimiter", REDelimiter(regexp.MustCompile(`\r?\n`)), "foo", 0},
+		{"re not at the end", REDelimiter(regexp.MustCompile(`o`)), "foo!", 0},
+		{"literal", LiteralDelimiter([]byte("\n"), []byte("\r\n")), "foo\r\n", 2},
+		{"literal without delimiter", LiteralDelimiter([]byte("\x00")), "foo", 0},
+		{"newline", NewlineDelimiter, "foo\n", 1},
+		{"crlf", NewlineDelimiter, "foo\r\n", 2},
+		{"no newline", NewlineDelimiter, "foo", 0},
+	}
+	for _, tt := range testdata {
+		if got := tt.d([]byte(tt.token)); got != tt.want {
+			t.Errorf("Delimiter(%v) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithDelimiter(t *testing.T) {
+	re := regexp.MustCompile(`\r?\n`)
+	input := "foo\r\nbar\n\nbaz"
+	testdata := []struct {
+		name string
+		mode DelimiterMode
+		term string
+		want []string
+		err  error
+	}{
+		{
+			name: "keep",
+			mode: Keep,
+			want: []string{"foo\r\n", "bar\n", "\n", "baz"},
+		},
+		{
+			name: "strip",
+			mode: Strip,
+			want: []string{"foo", "bar", "", "baz"},
+		},
+		{
+			name: "replace",
+			mode: Replace,
+			term: "\n",
+			want: []string{"foo\n", "bar\n", "\n", "baz"},
+		},
+		{
+			name: "move",
+			mode: Move,
+			want: []string{"foo", "\r\nbar", "\n", "\nbaz"},
+		},
+		{
+			name: "bad mode",
+			mode: DelimiterMode(-1),
+			err:  ErrBadDelimiterMode,
+		},
+	}
+	for _, tt := range testdata {
+		got, err := scanAll([]byte(input), WithDelimiter(ByRE(re), REDelimiter(re), tt.mode, []byte(tt.term)))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("WithDelimiter(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("WithDelimiter(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestWithDelimiter_move(t *testing.T) {
+	// The last delimiter of an input isn't carried over to the next one.
+	f := WithDelimiter(ByDelimiter([]byte(";")), LiteralDelimiter([]byte(";")), Move, nil)
+	for _, input := range []string{"a;b;", "c;d"} {
+		got, err := scanAll([]byte(input), f)
+		if err != nil {
+			t.Errorf("WithDelimiter(%q) error = %v", input, err)
+		}
+		want := []string{string(input[0]), ";" + string(input[2])}
+		if diff := pretty.Compare(got, want); diff != "" {
+			t.Errorf("WithDelimiter(%q) -got +want:\n%v", input, diff)
+		}
+	} // Empty tokens are kept.
+	got, err := scanAll([]byte("\n\n"), WithDelimiter(ByRE(regexp.MustCompile("\n")), NewlineDelimiter, Move, nil))
+	if err != nil {
+		t.Errorf("WithDelimiter(empty) error = %v", err)
+	}
+	if diff := pretty.Compare(got, []string{"", "\n"}); diff != "" {
+		t.Errorf("WithDelimiter(empty) -got +want:\n%v", diff)
+	}
+}
@@ -0,0 +1,164 @@
+package split
+
+import (
+	"bufio"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BySizeUTF8 is a closure for a SplitFunc that splits on a fixed byte size without splitting UTF-8 sequences.
+// Tokens end at the last rune boundary up to size, or right after the first rune if it's larger than size.
+// Invalid UTF-8 is split on any byte.
+func BySizeUTF8(size int) bufio.SplitFunc {
+	return bySize(size, nextRune)
+}
+
+// BySizeGrapheme is a closure for a SplitFunc that splits on a fixed byte size without splitting grapheme clusters,
+// i.e., user-perceived characters such as a letter with combining accents, an emoji sequence or a flag.
+// Tokens end at the last cluster boundary up to size, or right after the first cluster if it's larger than size.
+// Clusters are approximated with the unicode package as per the main rules of Unicode Standard Annex #29:
+// CRLF, Hangul syllables, combining marks, emoji modifiers, zero-width joiner sequences and regional indicator pairs
+// are kept together; a cluster is only known to be complete once the rune following it has been read or at EOF,
+// unless it ends in a control character such as a newline.
+func BySizeGrapheme(size int) bufio.SplitFunc {
+	return bySize(size, nextGrapheme)
+}
+
+// bySize is a closure for a SplitFunc that splits on a fixed byte size at the boundaries found by next,
+// which returns the length of the first unit in data, 0 if more data is needed.
+// Tokens are returned as soon as possible, but if there's enough data to fill a token then its last unit is waited for.
+func bySize(size int, next func(data []byte, atEOF bool) int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		n := 0
+		for n < len(data) && n < size {
+			l := next(data[n:], atEOF)
+			if l == 0 && len(data) == size && !atEOF {
+				// The incomplete unit may still fit.
+				return 0, nil, nil
+			}
+			if l == 0 || n > 0 && n+l > size {
+				break
+			}
+			n += l
+		}
+		if n == 0 {
+			return 0, nil, nil
+		}
+		return n, data[0:n], nil
+	}
+}
+
+// nextRune returns the length of the first rune in data, 0 if it's incomplete, 1 if it's invalid.
+func nextRune(data []byte, atEOF bool) int {
+	if !atEOF && !utf8.FullRune(data) {
+		return 0
+	}
+	_, n := utf8.DecodeRune(data)
+	return n
+}
+
+// nextGrapheme returns the length of the first grapheme cluster in data, 0 if it may be incomplete.
+func nextGrapheme(data []byte, atEOF bool) int {
+	n := nextRune(data, atEOF)
+	if n == 0 {
+		return 0
+	}
+	prev, _ := utf8.DecodeRune(data)
+	// ri is how many regional indicators precede the current rune.
+	ri := 0
+	for n < len(data) {
+		l := nextRune(data[n:], atEOF)
+		if l == 0 {
+			return 0
+		}
+		r, _ := utf8.DecodeRune(data[n:])
+		if isRegionalIndicator(prev) {
+			ri++
+		} else {
+			ri = 0
+		}
+		if !graphemeExtends(prev, r, ri) {
+			return n
+		}
+		prev = r
+		n += l
+	}
+	if !atEOF && !(unicode.IsControl(prev) && prev != '\r') {
+		// The next rune may still extend the cluster, nothing extends a control character other than CR.
+		return 0
+	}
+	return n
+}
+
+// graphemeExtends returns whether r belongs to the same grapheme cluster as the preceding rune prev,
+// ri is how many regional indicators precede r.
+func graphemeExtends(prev, r rune, ri int) bool {
+	const zwj = '\u200d'
+	switch {
+	case prev == '\r' && r == '\n':
+		return true
+	case unicode.IsControl(prev) || unicode.IsControl(r):
+		return false
+	case hangulExtends(prev, r):
+		return true
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) || r == zwj || isEmojiModifier(r):
+		return true
+	case prev == zwj && unicode.Is(unicode.So, r):
+		return true
+	case isRegionalIndicator(r):
+		// Flags are pairs of regional indicators.
+		return ri%2 == 1
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+func isEmojiModifier(r rune) bool {
+	return r >= 0x1f3fb && r <= 0x1f3ff
+}
+
+// hangulType is the type of a Hangul rune as per Unicode Standard Annex #29.
+type hangulType int
+
+const (
+	hangulNone hangulType = iota
+	hangulL
+	hangulV
+	hangulT
+	hangulLV
+	hangulLVT
+)
+
+func hangul(r rune) hangulType {
+	switch {
+	case r >= 0x1100 && r <= 0x115f, r >= 0xa960 && r <= 0xa97c:
+		return hangulL
+	case r >= 0x1160 && r <= 0x11a7, r >= 0xd7b0 && r <= 0xd7c6:
+		return hangulV
+	case r >= 0x11a8 && r <= 0x11ff, r >= 0xd7cb && r <= 0xd7fb:
+		return hangulT
+	case r >= 0xac00 && r <= 0xd7a3:
+		if (r-0xac00)%28 == 0 {
+			return hangulLV
+		}
+		return hangulLVT
+	}
+	return hangulNone
+}
+
+// hangulExtends returns whether r continues the Hangul syllable ending in prev.
+func hangulExtends(prev, r rune) bool {
+	next := hangul(r)
+	switch hangul(prev) {
+	case hangulL:
+		return next != hangulNone && next != hangulT
+	case hangulV, hangulLV:
+		return next == hangulV || next == hangulT
+	case hangulT, hangulLVT:
+		return next == hangulT
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package split
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestBySizeUTF8(t *testing.T) {
+	testdata := []struct {
+		name  string
+		f     func(int) bufio.SplitFunc
+		size  int
+		input string
+		want  []string
+	}{
+		{
+			name:  "rune",
+			f:     BySizeUTF8,
+			size:  4,
+			input: "héllo wörld",
+			want:  []string{"hél", "lo w", "örl", "d"},
+		},
+		{
+			name:  "rune larger than size",
+			f:     BySizeUTF8,
+			size:  1,
+			input: "日本",
+			want:  []string{"日", "本"},
+		},
+		{
+			name:  "invalid",
+			f:     BySizeUTF8,
+			size:  2,
+			input: "\xffab",
+			want:  []string{"\xffa", "b"},
+		},
+		{
+			name:  "rune splits combining marks",
+			f:     BySizeUTF8,
+			size:  2,
+			input: "e\u0301e\u0301",
+			want:  []string{"e", "\u0301", "e", "\u0301"},
+		},
+		{
+			name:  "combining marks",
+			f:     BySizeGrapheme,
+			size:  4,
+			input: "e\u0301e\u0301",
+			want:  []string{"e\u0301", "e\u0301"},
+		},
+		{
+			name:  "flags",
+			f:     BySizeGrapheme,
+			size:  12,
+			input: "🇺🇸🇫🇷",
+			want:  []string{"🇺🇸", "🇫🇷"},
+		},
+		{
+			name:  "hangul",
+			f:     BySizeGrapheme,
+			size:  3,
+			input: "한국각",
+			want:  []string{"한", "국", "각"},
+		},
+		{
+			name:  "emoji sequences",
+			f:     BySizeGrapheme,
+			size:  4,
+			input: "👨\u200d👩👍🏽",
+			want:  []string{"👨\u200d👩", "👍🏽"},
+		},
+		{
+			name:  "crlf",
+			f:     BySizeGrapheme,
+			size:  2,
+			input: "a\r\nb",
+			want:  []string{"a", "\r\n", "b"},
+		},
+	}
+	for _, tt := range testdata {
+		s := bufio.NewScanner(strings.NewReader(tt.input))
+		s.Split(tt.f(tt.size))
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if err := s.Err(); err != nil {
+			t.Errorf("BySizeUTF8(%v) error = %v", tt.name, err)
+		}
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("BySizeUTF8(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestBySizeUTF8_partialReads(t *testing.T) {
+	// Tokens are returned as soon as possible, but never end in the middle of a rune.
+	input := "héllo wörld 日本 e\u0301 🇺🇸"
+	testdata := map[string]bufio.SplitFunc{
+		"rune":     BySizeUTF8(4),
+		"grapheme": BySizeGrapheme(4),
+	}
+	for name, f := range testdata {
+		got, err := scanAll([]byte(input), f)
+		if err != nil {
+			t.Errorf("BySizeUTF8(%v) error = %v", name, err)
+		}
+		for _, tok := range got {
+			if !utf8.ValidString(tok) {
+				t.Errorf("BySizeUTF8(%v) returned invalid token %q", name, tok)
+			}
+		}
+		if s := strings.Join(got, ""); s != input {
+			t.Errorf("BySizeUTF8(%v) = %q, want %q", name, s, input)
+		}
+	}
+}
+
+func TestBySizeUTF8_full(t *testing.T) {
+	// A full token is returned as soon as where it ends is known.
+	testdata := []struct {
+		name  string
+		f     func(int) bufio.SplitFunc
+		input string
+		atEOF bool
+		want  string
+	}{
+		{
+			name:  "rune",
+			f:     BySizeUTF8,
+			input: "abcd",
+			want:  "abcd",
+		},
+		{
+			name:  "partial rune",
+			f:     BySizeUTF8,
+			input: "abc\xc3",
+		},
+		{
+			name:  "partial rune completed",
+			f:     BySizeUTF8,
+			input: "abc\xc3\xa9",
+			want:  "abc",
+		},
+		{
+			name:  "grapheme",
+			f:     BySizeGrapheme,
+			input: "abcd",
+		},
+		{
+			name:  "grapheme followed by next",
+			f:     BySizeGrapheme,
+			input: "abcde",
+			want:  "abcd",
+		},
+		{
+			name:  "grapheme followed by combining mark",
+			f:     BySizeGrapheme,
+			input: "abcd\u0301",
+			want:  "abc",
+		},
+		{
+			name:  "grapheme at EOF",
+			f:     BySizeGrapheme,
+			input: "abcd",
+			atEOF: true,
+			want:  "abcd",
+		},
+		{
+			name:  "grapheme newline",
+			f:     BySizeGrapheme,
+			input: "abc\n",
+			want:  "abc\n",
+		},
+		{
+			name:  "grapheme carriage return",
+			f:     BySizeGrapheme,
+			input: "abc\r",
+		},
+	}
+	for _, tt := range testdata {
+		advance, token, err := tt.f(4)([]byte(tt.input), tt.atEOF)
+		if err != nil {
+			t.Errorf("BySizeUTF8(%v) error = %v", tt.name, err)
+		}
+		if advance != len(tt.want) || string(token) != tt.want {
+			t.Errorf("BySizeUTF8(%v) = %v, %q, want %v, %q", tt.name, advance, token, len(tt.want), tt.want)
+		}
+	}
+}
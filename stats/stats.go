@@ -0,0 +1,316 @@
+// Package stats keeps track of progress and throughput statistics.
+// All methods are safe for concurrent use, and are no-ops on a nil *Stats.
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WaitBuckets are the upper bounds of the wait time histogram buckets.
+var WaitBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// New instantiates a Stats for an input of a given size, starting at a given offset.
+// The size is unknown if <= 0.
+func New(size, offset int64) *Stats {
+	return &Stats{
+		start:    time.Now(),
+		now:      time.Now,
+		size:     size,
+		base:     offset,
+		off:      offset,
+		waitHist: make([]int64, len(WaitBuckets)+1),
+	}
+}
+
+// Stats keeps track of progress and throughput statistics.
+type Stats struct {
+	chunks   int64
+	bytes    int64
+	errors   int64
+	rejected int64
+	timeouts int64
+	restarts int64
+	wait     int64
+	waitHist []int64
+	sleep    int64
+	off      int64
+	base     int64
+	size     int64
+	pos      func() (int64, bool)
+	posBase  int64
+	posSet   int32
+	start    time.Time
+	now      func() time.Time
+
+	// mu guards the input source being read.
+	mu       sync.Mutex
+	input    string
+	inputNum int
+	inputs   int
+}
+
+// AddChunk records a chunk of data of n bytes being written.
+func (s *Stats) AddChunk(n int) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.chunks, 1)
+	atomic.AddInt64(&s.bytes, int64(n))
+}
+
+// AddError records an error.
+func (s *Stats) AddError() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.errors, 1)
+}
+
+// AddRejected records a chunk of data being rejected by a wrapped command.
+func (s *Stats) AddRejected() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.rejected, 1)
+}
+
+// AddTimeout records a timeout waiting for a wrapped command.
+func (s *Stats) AddTimeout() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.timeouts, 1)
+}
+
+// AddRestart records a wrapped command being restarted.
+func (s *Stats) AddRestart() {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.restarts, 1)
+}
+
+// AddWait records time spent waiting for the throttler to be ready.
+func (s *Stats) AddWait(d time.Duration) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.wait, int64(d))
+	i := sort.Search(len(WaitBuckets), func(i int) bool { return d <= WaitBuckets[i] })
+	atomic.AddInt64(&s.waitHist[i], 1)
+}
+
+// AddSleep records time spent sleeping between chunks of data, e.g., to stay under a rate limit.
+func (s *Stats) AddSleep(d time.Duration) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.sleep, int64(d))
+}
+
+// SetOffset records how much input has been consumed.
+func (s *Stats) SetOffset(offset int64) {
+	if s == nil {
+		return
+	}
+	atomic.StoreInt64(&s.off, offset)
+	if s.pos != nil && s.base > 0 && atomic.CompareAndSwapInt32(&s.posSet, 0, 1) {
+		// Resumed input is consumed up to here before the first chunk of data.
+		if pos, ok := s.pos(); ok {
+			atomic.StoreInt64(&s.posBase, pos)
+		}
+	}
+}
+
+// SetPosition reports progress as the position returned by f instead of the offset, as long as f returns true,
+// e.g., how much of a compressed input has been read as opposed to how much decompressed data has been consumed.
+// It must be called before any progress is recorded.
+func (s *Stats) SetPosition(f func() (int64, bool)) {
+	if s == nil {
+		return
+	}
+	s.pos = f
+}
+
+// SetInput records the input source being read, e.g., an input file, the nth of a total number of them.
+func (s *Stats) SetInput(name string, n, total int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.input, s.inputNum, s.inputs = name, n, total
+}
+
+// Snapshot returns the current statistics.
+func (s *Stats) Snapshot() Snapshot {
+	if s == nil {
+		return Snapshot{}
+	}
+	hist := make([]int64, len(s.waitHist))
+	for i := range hist {
+		hist[i] = atomic.LoadInt64(&s.waitHist[i])
+	}
+	off, base := atomic.LoadInt64(&s.off), s.base
+	if s.pos != nil {
+		if pos, ok := s.pos(); ok {
+			off, base = pos, atomic.LoadInt64(&s.posBase)
+		}
+	}
+	s.mu.Lock()
+	input, inputNum, inputs := s.input, s.inputNum, s.inputs
+	s.mu.Unlock()
+	return Snapshot{
+		Chunks:   atomic.LoadInt64(&s.chunks),
+		Bytes:    atomic.LoadInt64(&s.bytes),
+		Errors:   atomic.LoadInt64(&s.errors),
+		Rejected: atomic.LoadInt64(&s.rejected),
+		Timeouts: atomic.LoadInt64(&s.timeouts),
+		Restarts: atomic.LoadInt64(&s.restarts),
+		Wait:     time.Duration(atomic.LoadInt64(&s.wait)),
+		WaitHist: hist,
+		Sleep:    time.Duration(atomic.LoadInt64(&s.sleep)),
+		Elapsed:  s.now().Sub(s.start),
+		Offset:   off,
+		Base:     base,
+		Size:     s.size,
+		Input:    input,
+		InputNum: inputNum,
+		Inputs:   inputs,
+	}
+}
+
+// Report writes a snapshot to w every interval, until the returned function is called.
+func (s *Stats) Report(w io.Writer, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				fmt.Fprintln(w, s.Snapshot())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// A Snapshot is a point-in-time copy of the statistics.
+type Snapshot struct {
+	// Chunks is how many chunks of data have been written.
+	Chunks int64
+
+	// Bytes is how many bytes of data have been written.
+	Bytes int64
+
+	// Errors is how many errors have occurred.
+	Errors int64
+
+	// Rejected is how many chunks of data have been rejected by a wrapped command.
+	Rejected int64
+
+	// Timeouts is how many times waiting for a wrapped command has timed out.
+	Timeouts int64
+
+	// Restarts is how many times a wrapped command has been restarted.
+	Restarts int64
+
+	// Wait is how long has been spent waiting for the throttler to be ready.
+	Wait time.Duration
+
+	// WaitHist is how many waits fell into each of WaitBuckets, the last element counts waits exceeding all buckets.
+	WaitHist []int64
+
+	// Sleep is how long has been spent sleeping between chunks of data.
+	Sleep time.Duration
+
+	// Elapsed is how long it's been since the statistics started.
+	Elapsed time.Duration
+
+	// Offset is how much input has been consumed, or its position if set via SetPosition.
+	Offset int64
+
+	// Base is the input offset when the statistics started.
+	Base int64
+
+	// Size is the input size, unknown if <= 0.
+	Size int64
+
+	// Input is the name of the input source being read, if set via SetInput.
+	Input string
+
+	// InputNum is which of the Inputs input sources is being read, starting at 1.
+	InputNum, Inputs int
+}
+
+// Done returns the fraction of the input that has been consumed, returns false if the input size is unknown.
+func (s Snapshot) Done() (float64, bool) {
+	if s.Size <= 0 {
+		return 0, false
+	}
+	return float64(s.Offset) / float64(s.Size), true
+}
+
+// ETA returns the estimated time left to consume the input,
+// returns false if the input size is unknown or no progress has been made yet.
+func (s Snapshot) ETA() (time.Duration, bool) {
+	progress := s.Offset - s.Base
+	if s.Size <= 0 || progress <= 0 || s.Elapsed <= 0 {
+		return 0, false
+	}
+	left := s.Size - s.Offset
+	if left < 0 {
+		left = 0
+	}
+	return time.Duration(float64(s.Elapsed) * float64(left) / float64(progress)), true
+}
+
+// String formats a snapshot as space-separated key=value pairs.
+func (s Snapshot) String() string {
+	var chunkRate, byteRate float64
+	if secs := s.Elapsed.Seconds(); secs > 0 {
+		chunkRate = float64(s.Chunks) / secs
+		byteRate = float64(s.Bytes) / secs
+	}
+	str := fmt.Sprintf("chunks=%v bytes=%v elapsed=%v chunk_rate=%.1f/s byte_rate=%.1f/s wait=%v sleep=%v errors=%v rejected=%v",
+		s.Chunks, s.Bytes, s.Elapsed.Round(time.Millisecond), chunkRate, byteRate,
+		s.Wait.Round(time.Millisecond), s.Sleep.Round(time.Millisecond), s.Errors, s.Rejected)
+	if done, ok := s.Done(); ok {
+		str += fmt.Sprintf(" done=%.1f%%", 100*done)
+	}
+	if eta, ok := s.ETA(); ok {
+		str += fmt.Sprintf(" eta=%v", eta.Round(time.Second))
+	}
+	if s.Input != "" {
+		str += fmt.Sprintf(" input=%q inputs=%v/%v", s.Input, s.InputNum, s.Inputs)
+	}
+	return str
+}
@@ -0,0 +1,155 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestStats(t *testing.T) {
+	s := New(1000, 100)
+	s.now = func() time.Time { return s.start.Add(10 * time.Second) }
+	for i := 0; i < 5; i++ {
+		s.AddChunk(20)
+	}
+	s.AddError()
+	s.AddRejected()
+	s.AddWait(time.Second)
+	s.AddWait(500 * time.Millisecond)
+	s.AddWait(time.Hour)
+	s.AddTimeout()
+	s.AddRestart()
+	s.AddRestart()
+	s.AddSleep(2 * time.Second)
+	s.SetOffset(400)
+	s.SetInput("b.log", 2, 3)
+	want := Snapshot{
+		Chunks:   5,
+		Bytes:    100,
+		Errors:   1,
+		Rejected: 1,
+		Timeouts: 1,
+		Restarts: 2,
+		Wait:     time.Hour + 1500*time.Millisecond,
+		WaitHist: []int64{0, 0, 0, 0, 0, 0, 0, 1, 1, 0, 0, 0, 0, 0, 1},
+		Sleep:    2 * time.Second,
+		Elapsed:  10 * time.Second,
+		Offset:   400,
+		Base:     100,
+		Size:     1000,
+		Input:    "b.log",
+		InputNum: 2,
+		Inputs:   3,
+	}
+	got := s.Snapshot()
+	if diff := pretty.Compare(got, want); diff != "" {
+		t.Errorf("Snapshot() -got +want:\n%v", diff)
+	}
+	wantStr := "chunks=5 bytes=100 elapsed=10s chunk_rate=0.5/s byte_rate=10.0/s wait=1h0m1.5s sleep=2s errors=1 rejected=1 done=40.0% eta=20s input=\"b.log\" inputs=2/3"
+	if got := got.String(); got != wantStr {
+		t.Errorf("String() = %q, want %q", got, wantStr)
+	}
+}
+
+func TestSetPosition(t *testing.T) {
+	testdata := []struct {
+		name       string
+		offset     int64
+		pos        int64
+		compressed bool
+		want       Snapshot
+	}{
+		{
+			name:       "compressed",
+			pos:        250,
+			compressed: true,
+			want:       Snapshot{Offset: 250, Size: 1000},
+		},
+		{
+			name:       "resumed compressed",
+			offset:     100,
+			pos:        250,
+			compressed: true,
+			want:       Snapshot{Offset: 250, Base: 50, Size: 1000},
+		},
+		{
+			name: "not compressed",
+			pos:  250,
+			want: Snapshot{Offset: 400, Size: 1000},
+		},
+	}
+	for _, tt := range testdata {
+		s := New(1000, tt.offset)
+		s.now = func() time.Time { return s.start }
+		pos := int64(50)
+		s.SetPosition(func() (int64, bool) { return pos, tt.compressed })
+		s.SetOffset(200)
+		pos = tt.pos
+		s.SetOffset(400)
+		got := s.Snapshot()
+		got.WaitHist = nil
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("Snapshot(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestSnapshot_unknownSize(t *testing.T) {
+	s := Snapshot{Offset: 10, Elapsed: time.Second}
+	if _, ok := s.Done(); ok {
+		t.Error("Done() ok = true")
+	}
+	if _, ok := s.ETA(); ok {
+		t.Error("ETA() ok = true")
+	}
+	if got := s.String(); strings.Contains(got, "done=") || strings.Contains(got, "eta=") {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestNil(t *testing.T) {
+	var s *Stats
+	s.AddChunk(1)
+	s.AddError()
+	s.AddRejected()
+	s.AddWait(time.Second)
+	s.AddSleep(time.Second)
+	s.AddTimeout()
+	s.AddRestart()
+	s.SetOffset(1)
+	s.SetPosition(nil)
+	s.SetInput("a.log", 1, 1)
+	if diff := pretty.Compare(s.Snapshot(), Snapshot{}); diff != "" {
+		t.Errorf("Snapshot() -got +want:\n%v", diff)
+	}
+}
+
+type syncBuilder struct {
+	c chan string
+}
+
+func (s syncBuilder) Write(b []byte) (int, error) {
+	select {
+	case s.c <- string(b):
+	default:
+	}
+	return len(b), nil
+}
+
+func TestReport(t *testing.T) {
+	w := syncBuilder{make(chan string, 10)}
+	s := New(0, 0)
+	s.AddChunk(3)
+	stop := s.Report(w, time.Millisecond)
+	select {
+	case got := <-w.c:
+		if !strings.HasPrefix(got, "chunks=1 bytes=3 ") {
+			t.Errorf("Report() = %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout")
+	}
+	stop()
+}
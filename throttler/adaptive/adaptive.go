@@ -0,0 +1,187 @@
+// Package adaptive implements a throttler that tunes its own rate to the wrapped throttler's latency.
+// It uses additive-increase/multiplicative-decrease (AIMD) to converge on the fastest rate
+// that keeps the time between writing a chunk of data and the wrapped throttler being ready again under a target.
+package adaptive
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/stats"
+	"github.com/hazaelsan/pipe-throttler/throttler"
+)
+
+const (
+	// DefaultMinRate is the default lowest rate, in chunks per second.
+	DefaultMinRate = 0.1
+
+	// DefaultIncrease is the default additive increase, in chunks per second.
+	DefaultIncrease = 1
+
+	// DefaultDecrease is the default multiplicative decrease factor.
+	DefaultDecrease = 0.5
+)
+
+var (
+	// ErrNoTarget is returned when no target latency is set.
+	ErrNoTarget = errors.New("no target latency")
+
+	// ErrBadDecrease is returned when the decrease factor is not between 0 and 1.
+	ErrBadDecrease = errors.New("decrease factor must be > 0 and < 1")
+
+	// ErrBadRate is returned when the maximum rate is lower than the minimum rate.
+	ErrBadRate = errors.New("maximum rate must be >= minimum rate")
+)
+
+// Options is a set of options to instantiate an Adaptive throttler.
+type Options struct {
+	// Target is the highest acceptable latency, measured from writing a chunk of data
+	// until the wrapped throttler is ready for the next one.
+	// The wrapped throttler must process one chunk of data at a time, e.g., not an expect.Pool with multiple workers.
+	Target time.Duration
+
+	// MinRate is the lowest rate in chunks per second, DefaultMinRate if <= 0.
+	MinRate float64
+
+	// MaxRate is the highest rate in chunks per second, unlimited if <= 0.
+	MaxRate float64
+
+	// Increase is how many chunks per second to add to the rate after each round trip under Target,
+	// DefaultIncrease if <= 0.
+	Increase float64
+
+	// Decrease is the factor by which to multiply the rate after each round trip over Target,
+	// DefaultDecrease if 0.
+	Decrease float64
+
+	// Stats, if set, keeps track of time spent pacing writes.
+	Stats *stats.Stats
+}
+
+// New instantiates an Adaptive throttler wrapping another throttler, starting at the minimum rate.
+func New(t throttler.Throttler, opts Options) (*Adaptive, error) {
+	if opts.Target <= 0 {
+		return nil, ErrNoTarget
+	}
+	if opts.MinRate <= 0 {
+		opts.MinRate = DefaultMinRate
+	}
+	if opts.MaxRate > 0 && opts.MaxRate < opts.MinRate {
+		return nil, ErrBadRate
+	}
+	if opts.Increase <= 0 {
+		opts.Increase = DefaultIncrease
+	}
+	if opts.Decrease == 0 {
+		opts.Decrease = DefaultDecrease
+	}
+	if opts.Decrease <= 0 || opts.Decrease >= 1 {
+		return nil, ErrBadDecrease
+	}
+	return &Adaptive{
+		t:     t,
+		opts:  opts,
+		rate:  opts.MinRate,
+		now:   time.Now,
+		sleep: time.Sleep,
+	}, nil
+}
+
+// An Adaptive is a latency-driven AIMD throttler.
+// Writes are paced at the current rate, the rate is adjusted each time the wrapped throttler becomes ready.
+type Adaptive struct {
+	t       throttler.Throttler
+	opts    Options
+	mu      sync.Mutex
+	rate    float64
+	latency time.Duration
+	last    time.Time
+	sent    time.Time
+	now     func() time.Time
+	sleep   func(time.Duration)
+}
+
+// Rate returns the current rate in chunks per second.
+func (a *Adaptive) Rate() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rate
+}
+
+// Latency returns the last measured latency.
+func (a *Adaptive) Latency() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.latency
+}
+
+// adjust updates the rate after a round trip took d.
+func (a *Adaptive) adjust(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.latency = d
+	if d > a.opts.Target {
+		a.rate *= a.opts.Decrease
+		if a.rate < a.opts.MinRate {
+			a.rate = a.opts.MinRate
+		}
+		return
+	}
+	a.rate += a.opts.Increase
+	if a.opts.MaxRate > 0 && a.rate > a.opts.MaxRate {
+		a.rate = a.opts.MaxRate
+	}
+}
+
+// delay returns how long to wait before the next chunk of data can be written at the current rate.
+func (a *Adaptive) delay(now time.Time) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.last.IsZero() {
+		return 0
+	}
+	return a.last.Add(time.Duration(float64(time.Second) / a.rate)).Sub(now)
+}
+
+// Start starts up the throttler.
+func (a *Adaptive) Start() error {
+	return a.t.Start()
+}
+
+// Stop shuts down the throttler.
+func (a *Adaptive) Stop() error {
+	return a.t.Stop()
+}
+
+// DoneRead indicates that there is no more data to be read into the throttler.
+func (a *Adaptive) DoneRead() error {
+	return a.t.DoneRead()
+}
+
+// Wait blocks until the wrapped throttler can write more data,
+// the time since the last chunk of data was written is used to adjust the rate.
+func (a *Adaptive) Wait() error {
+	if err := a.t.Wait(); err != nil {
+		return err
+	}
+	if !a.sent.IsZero() {
+		a.adjust(a.now().Sub(a.sent))
+		a.sent = time.Time{}
+	}
+	return nil
+}
+
+// Write waits until the next chunk of data can be written at the current rate and writes it to the wrapped throttler.
+func (a *Adaptive) Write(b []byte) (int, error) {
+	if d := a.delay(a.now()); d > 0 {
+		a.sleep(d)
+		a.opts.Stats.AddSleep(d)
+	}
+	now := a.now()
+	a.mu.Lock()
+	a.last = now
+	a.mu.Unlock()
+	a.sent = now
+	return a.t.Write(b)
+}
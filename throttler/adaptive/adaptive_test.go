@@ -0,0 +1,154 @@
+package adaptive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/stats"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// clock is a fake clock, sleeping advances the current time.
+type clock struct {
+	t      time.Time
+	sleeps []time.Duration
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) sleep(d time.Duration) {
+	c.sleeps = append(c.sleeps, d)
+	c.t = c.t.Add(d)
+}
+
+// fake is a throttler that takes a scripted amount of time to become ready after each write.
+type fake struct {
+	c         *clock
+	latencies []time.Duration
+	writes    int
+}
+
+func (*fake) Start() error    { return nil }
+func (*fake) Stop() error     { return nil }
+func (*fake) DoneRead() error { return nil }
+
+func (f *fake) Wait() error {
+	if f.writes > 0 {
+		f.c.t = f.c.t.Add(f.latencies[f.writes-1])
+	}
+	return nil
+}
+
+func (f *fake) Write(b []byte) (int, error) {
+	f.writes++
+	return len(b), nil
+}
+
+func TestNew(t *testing.T) {
+	testdata := []struct {
+		name string
+		opts Options
+		want error
+	}{
+		{
+			name: "defaults",
+			opts: Options{Target: time.Second},
+		},
+		{
+			name: "no target",
+			want: ErrNoTarget,
+		},
+		{
+			name: "bad decrease",
+			opts: Options{Target: time.Second, Decrease: 1.5},
+			want: ErrBadDecrease,
+		},
+		{
+			name: "bad rate",
+			opts: Options{Target: time.Second, MinRate: 2, MaxRate: 1},
+			want: ErrBadRate,
+		},
+	}
+	for _, tt := range testdata {
+		if _, err := New(nil, tt.opts); err != tt.want {
+			t.Errorf("New(%v) error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestAdaptive(t *testing.T) {
+	testdata := []struct {
+		name      string
+		opts      Options
+		latencies []time.Duration
+		rates     []float64
+		sleeps    []time.Duration
+	}{
+		{
+			name:      "increase",
+			opts:      Options{Target: time.Second, MinRate: 1},
+			latencies: []time.Duration{0, 0, 0},
+			rates:     []float64{2, 3, 4},
+			sleeps:    []time.Duration{500 * time.Millisecond, 333333333},
+		},
+		{
+			name:      "max rate",
+			opts:      Options{Target: time.Second, MinRate: 1, MaxRate: 2},
+			latencies: []time.Duration{0, 0, 0},
+			rates:     []float64{2, 2, 2},
+			sleeps:    []time.Duration{500 * time.Millisecond, 500 * time.Millisecond},
+		},
+		{
+			name:      "decrease",
+			opts:      Options{Target: time.Second, MinRate: 1, Increase: 3},
+			latencies: []time.Duration{0, 2 * time.Second, 0},
+			rates:     []float64{4, 2, 5},
+			sleeps:    []time.Duration{250 * time.Millisecond},
+		},
+		{
+			name:      "min rate",
+			opts:      Options{Target: time.Second, MinRate: 1},
+			latencies: []time.Duration{2 * time.Second, 2 * time.Second},
+			rates:     []float64{1, 1},
+		},
+	}
+	for _, tt := range testdata {
+		c := &clock{t: time.Unix(0, 0)}
+		f := &fake{c: c, latencies: tt.latencies}
+		tt.opts.Stats = stats.New(0, 0)
+		a, err := New(f, tt.opts)
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		a.now = c.now
+		a.sleep = c.sleep
+		if err := a.Wait(); err != nil {
+			t.Errorf("Wait(%v) error = %v", tt.name, err)
+		}
+		var rates []float64
+		for range tt.latencies {
+			if _, err := a.Write([]byte("a")); err != nil {
+				t.Errorf("Write(%v) error = %v", tt.name, err)
+			}
+			if err := a.Wait(); err != nil {
+				t.Errorf("Wait(%v) error = %v", tt.name, err)
+			}
+			rates = append(rates, a.Rate())
+		}
+		if diff := pretty.Compare(rates, tt.rates); diff != "" {
+			t.Errorf("Rate(%v) -got +want:\n%v", tt.name, diff)
+		}
+		if diff := pretty.Compare(c.sleeps, tt.sleeps); diff != "" {
+			t.Errorf("Write(%v) sleeps -got +want:\n%v", tt.name, diff)
+		}
+		var want time.Duration
+		for _, d := range tt.sleeps {
+			want += d
+		}
+		if got := tt.opts.Stats.Snapshot().Sleep; got != want {
+			t.Errorf("Write(%v) Stats.Sleep = %v, want %v", tt.name, got, want)
+		}
+	}
+}
@@ -6,12 +6,20 @@ import "io"
 
 // New instantiates a dummy throttler.
 func New(w io.WriteCloser) *Dummy {
-	return &Dummy{w}
+	return &Dummy{w: w}
+}
+
+// NewWithHeader instantiates a dummy throttler that writes a header (e.g., a CSV header row)
+// before the first chunk of data, header returns nil if there's none.
+func NewWithHeader(w io.WriteCloser, header func() []byte) *Dummy {
+	return &Dummy{w: w, header: header}
 }
 
 // A Dummy is a dummy throttler.
 type Dummy struct {
-	w io.WriteCloser
+	w      io.WriteCloser
+	header func() []byte
+	headed bool
 }
 
 // Start starts up the throttler.
@@ -36,5 +44,13 @@ func (*Dummy) Wait() error {
 
 // Write writes the next chunk of data to the underlying Writer.
 func (d *Dummy) Write(b []byte) (int, error) {
+	if d.header != nil && !d.headed {
+		if h := d.header(); h != nil {
+			d.headed = true
+			if _, err := d.w.Write(h); err != nil {
+				return 0, err
+			}
+		}
+	}
 	return d.w.Write(b)
 }
@@ -46,3 +46,18 @@ func TestWrite(t *testing.T) {
 		t.Error("Close() error = nil")
 	}
 }
+
+func TestWrite_header(t *testing.T) {
+	var header []byte
+	wc := new(writeCloser)
+	s := NewWithHeader(wc, func() []byte { return header })
+	for _, data := range []string{"early\n", "foo\n", "bar\n"} {
+		if _, err := s.Write([]byte(data)); err != nil {
+			t.Errorf("Write(%v) error = %v", data, err)
+		}
+		header = []byte("h\n")
+	}
+	if got, want := wc.String(), "early\nh\nfoo\nbar\n"; got != want {
+		t.Errorf("Write() = %q, want %q", got, want)
+	}
+}
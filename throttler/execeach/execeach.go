@@ -0,0 +1,203 @@
+// Package execeach implements a throttler that runs a command once per chunk of data, much like xargs.
+// The chunk of data is either written to the command's stdin or substituted into its arguments.
+package execeach
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hazaelsan/pipe-throttler/internal/syncio"
+)
+
+// Placeholder is replaced by the chunk of data in the command's arguments.
+const Placeholder = "{}"
+
+var (
+	// ErrNoCommand is returned when there's no command to execute.
+	ErrNoCommand = errors.New("no command to execute")
+
+	// ErrNoConcurrency is returned when the concurrency limit is < 1.
+	ErrNoConcurrency = errors.New("concurrency must be >= 1")
+)
+
+// A FailedError is returned by Stop when any of the commands failed.
+type FailedError struct {
+	// Failed is how many commands exited with a non-zero status.
+	Failed int
+
+	// Total is how many commands were run.
+	Total int
+
+	// Statuses is how many commands exited with each non-zero status, -1 if killed by a signal.
+	Statuses map[int]int
+}
+
+func (e *FailedError) Error() string {
+	var codes []int
+	for code := range e.Statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	var ss []string
+	for _, code := range codes {
+		ss = append(ss, fmt.Sprintf("status %v: %v", code, e.Statuses[code]))
+	}
+	return fmt.Sprintf("%v of %v commands failed (%v)", e.Failed, e.Total, strings.Join(ss, ", "))
+}
+
+// Options is a set of options to instantiate an ExecEach throttler.
+type Options struct {
+	// Command is the command to execute for each chunk of data.
+	// If any argument contains Placeholder then it's replaced by the chunk of data (minus any trailing NUL or newline, LF or CRLF),
+	// otherwise the chunk of data is written to the command's stdin.
+	Command []string
+
+	// Concurrency is how many commands can run at the same time.
+	Concurrency int
+
+	// Header, if set, returns a header (e.g., a CSV header row) to write to each command's stdin before the chunk of data,
+	// it's not used if the chunk of data is passed as an argument.
+	Header func() []byte
+}
+
+// New instantiates an ExecEach throttler.
+func New(opts Options) (*ExecEach, error) {
+	if len(opts.Command) == 0 {
+		return nil, ErrNoCommand
+	}
+	if opts.Concurrency < 1 {
+		return nil, ErrNoConcurrency
+	}
+	e := &ExecEach{
+		opts:     opts,
+		stdout:   syncio.NewWriter(os.Stdout),
+		stderr:   syncio.NewWriter(os.Stderr),
+		slots:    make(chan struct{}, opts.Concurrency),
+		statuses: make(map[int]int),
+	}
+	for _, arg := range opts.Command {
+		if strings.Contains(arg, Placeholder) {
+			e.substitute = true
+		}
+	}
+	return e, nil
+}
+
+// An ExecEach is a throttler that runs a command for each chunk of data.
+// Waiting blocks until fewer than the concurrency limit of commands are running.
+type ExecEach struct {
+	opts       Options
+	substitute bool
+	stdout     io.Writer
+	stderr     io.Writer
+	slots      chan struct{}
+	reserved   bool
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+	total      int
+	failed     int
+	statuses   map[int]int
+}
+
+// command returns the command to run for a chunk of data.
+func (e *ExecEach) command(b []byte) *exec.Cmd {
+	args := e.opts.Command
+	if e.substitute {
+		s := strings.TrimSuffix(string(b), "\x00")
+		if strings.HasSuffix(s, "\n") {
+			s = strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+		}
+		args = make([]string, len(e.opts.Command))
+		for i, arg := range e.opts.Command {
+			args[i] = strings.ReplaceAll(arg, Placeholder, s)
+		}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	if !e.substitute {
+		var h []byte
+		if e.opts.Header != nil {
+			h = e.opts.Header()
+		}
+		cmd.Stdin = io.MultiReader(bytes.NewReader(h), bytes.NewReader(b))
+	}
+	cmd.Stdout = e.stdout
+	cmd.Stderr = e.stderr
+	return cmd
+}
+
+// run waits for a command to exit and keeps track of its exit status.
+func (e *ExecEach) run(cmd *exec.Cmd) {
+	defer e.wg.Done()
+	err := cmd.Wait()
+	<-e.slots
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err == nil {
+		return
+	}
+	e.failed++
+	fmt.Fprintf(e.stderr, "%v: %v\n", cmd.Path, err)
+	code := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+	}
+	e.statuses[code]++
+}
+
+// Start starts up the throttler.
+func (*ExecEach) Start() error {
+	return nil
+}
+
+// Stop waits for all commands to exit.
+// Returns a *FailedError if any of them failed.
+func (e *ExecEach) Stop() error {
+	e.wg.Wait()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failed > 0 {
+		return &FailedError{Failed: e.failed, Total: e.total, Statuses: e.statuses}
+	}
+	return nil
+}
+
+// DoneRead is a no-op for this throttler.
+func (*ExecEach) DoneRead() error {
+	return nil
+}
+
+// Wait blocks until another command can be run without exceeding the concurrency limit.
+func (e *ExecEach) Wait() error {
+	if !e.reserved {
+		e.slots <- struct{}{}
+		e.reserved = true
+	}
+	return nil
+}
+
+// Write runs the command for the next chunk of data, without waiting for it to exit.
+func (e *ExecEach) Write(b []byte) (int, error) {
+	if err := e.Wait(); err != nil {
+		return 0, err
+	}
+	e.reserved = false
+	cmd := e.command(b)
+	if err := cmd.Start(); err != nil {
+		<-e.slots
+		return 0, err
+	}
+	e.mu.Lock()
+	e.total++
+	e.mu.Unlock()
+	e.wg.Add(1)
+	go e.run(cmd)
+	return len(b), nil
+}
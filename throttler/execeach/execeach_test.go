@@ -0,0 +1,175 @@
+package execeach
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/internal/syncio"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestNew(t *testing.T) {
+	testdata := []struct {
+		name string
+		opts Options
+		err  error
+	}{
+		{
+			name: "good",
+			opts: Options{Command: []string{"cat"}, Concurrency: 1},
+		},
+		{
+			name: "no command",
+			opts: Options{Concurrency: 1},
+			err:  ErrNoCommand,
+		},
+		{
+			name: "no concurrency",
+			opts: Options{Command: []string{"cat"}},
+			err:  ErrNoConcurrency,
+		},
+	}
+	for _, tt := range testdata {
+		if _, err := New(tt.opts); !errors.Is(err, tt.err) {
+			t.Errorf("New(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestExecEach(t *testing.T) {
+	testdata := []struct {
+		name    string
+		command []string
+		chunks  []string
+		header  string
+		want    []string
+		err     *FailedError
+	}{
+		{
+			name:    "stdin",
+			command: []string{"sed", "s/^/got /"},
+			chunks:  []string{"foo\n", "bar\n"},
+			want:    []string{"got bar", "got foo"},
+		},
+		{
+			name:    "substitute",
+			command: []string{"echo", "arg={}", "{}"},
+			chunks:  []string{"foo\n", "bar\r\n", "baz", "quux\x00"},
+			want:    []string{"arg=bar bar", "arg=baz baz", "arg=foo foo", "arg=quux quux"},
+		},
+		{
+			name:    "substitute lone carriage return",
+			command: []string{"sh", "-c", `printf "%s\n" "$0" | tr "\r" R`, "{}"},
+			chunks:  []string{"foo\r", "bar\r\n"},
+			want:    []string{"bar", "fooR"},
+		},
+		{
+			name:    "header",
+			command: []string{"paste", "-sd,"},
+			chunks:  []string{"foo\n", "bar\n"},
+			header:  "h\n",
+			want:    []string{"h,bar", "h,foo"},
+		},
+		{
+			name:    "header with substitute",
+			command: []string{"echo", "{}"},
+			chunks:  []string{"foo\n"},
+			header:  "h\n",
+			want:    []string{"foo"},
+		},
+		{
+			name:    "failures",
+			command: []string{"sh", "-c", "echo $0; exit $0", "{}"},
+			chunks:  []string{"0\n", "1\n", "2\n", "1\n"},
+			want:    []string{"0", "1", "1", "2"},
+			err:     &FailedError{Failed: 3, Total: 4, Statuses: map[int]int{1: 2, 2: 1}},
+		},
+	}
+	for _, tt := range testdata {
+		opts := Options{Command: tt.command, Concurrency: 2}
+		if tt.header != "" {
+			opts.Header = func() []byte { return []byte(tt.header) }
+		}
+		e, err := New(opts)
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		stdout := new(strings.Builder)
+		e.stdout = syncio.NewWriter(stdout)
+		e.stderr = syncio.NewWriter(new(strings.Builder))
+		if err := e.Start(); err != nil {
+			t.Errorf("Start(%v) error = %v", tt.name, err)
+		}
+		for _, chunk := range tt.chunks {
+			if err := e.Wait(); err != nil {
+				t.Errorf("Wait(%v) error = %v", tt.name, err)
+			}
+			if _, err := e.Write([]byte(chunk)); err != nil {
+				t.Errorf("Write(%v) error = %v", tt.name, err)
+			}
+		}
+		if err := e.DoneRead(); err != nil {
+			t.Errorf("DoneRead(%v) error = %v", tt.name, err)
+		}
+		err = e.Stop()
+		var got *FailedError
+		if errors.As(err, &got) != (tt.err != nil) {
+			t.Errorf("Stop(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if diff := pretty.Compare(got, tt.err); diff != "" {
+			t.Errorf("Stop(%v) error -got +want:\n%v", tt.name, diff)
+		}
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		sort.Strings(lines)
+		if diff := pretty.Compare(lines, tt.want); diff != "" {
+			t.Errorf("stdout(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestExecEach_concurrency(t *testing.T) {
+	e, err := New(Options{Command: []string{"sleep", "0.2"}, Concurrency: 2})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := e.Wait(); err != nil {
+			t.Errorf("Wait() error = %v", err)
+		}
+		if _, err := e.Write(nil); err != nil {
+			t.Errorf("Write() error = %v", err)
+		}
+	}
+	// The third command can only start once one of the first two has exited.
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("Write() took %v, want >= 200ms", d)
+	}
+	if err := e.Stop(); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+}
+
+func TestExecEach_startError(t *testing.T) {
+	e, err := New(Options{Command: []string{"/nonexistent/command"}, Concurrency: 1})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := e.Write([]byte("foo")); err == nil {
+		t.Error("Write() error = nil")
+	}
+	// The slot is released again.
+	if err := e.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+}
+
+func TestFailedError(t *testing.T) {
+	err := &FailedError{Failed: 3, Total: 10, Statuses: map[int]int{2: 1, 1: 2}}
+	if got, want := err.Error(), "3 of 10 commands failed (status 1: 2, status 2: 1)"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
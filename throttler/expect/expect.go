@@ -7,7 +7,14 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/hazaelsan/pipe-throttler/split"
+	"github.com/hazaelsan/pipe-throttler/stats"
+	"github.com/hazaelsan/pipe-throttler/throttler"
 )
 
 var (
@@ -17,6 +24,12 @@ var (
 	// ErrNoCommand is returned when there's no command to execute.
 	ErrNoCommand = errors.New("no command to execute")
 
+	// ErrRejected is matched by the *throttler.Rejection returned when the wrapped command rejects a chunk of data.
+	ErrRejected = throttler.ErrRejected
+
+	// ErrNoPTY is returned when pseudo-terminals are not supported on this platform.
+	ErrNoPTY = errors.New("pseudo-terminals not supported")
+
 	// ErrTimeout is returned when the read timeout is exceeded.
 	ErrTimeout = errors.New("read timeout exceeded")
 )
@@ -33,9 +46,79 @@ type Options struct {
 	// SplitFunc is the function to use to split output from the wrapped command.
 	SplitFunc bufio.SplitFunc
 
+	// MaxChunkSize is the maximum size of an output token as returned by SplitFunc, bufio.MaxScanTokenSize if <= 0.
+	// With a Script, only the last MaxChunkSize bytes of output not yet matched by an expect step are kept.
+	MaxChunkSize int
+
+	// Oversize is what to do with output tokens larger than MaxChunkSize, see split.WithMaxSize.
+	// Skipped output tokens are neither matched nor written out.
+	Oversize split.OversizePolicy
+
+	// Delimiter, if set, finds the delimiter at the end of output tokens as split by SplitFunc,
+	// truncated tokens keep it, see split.WithMaxSize.
+	Delimiter split.Delimiter
+
+	// Ready, if set, only lets output tokens matching it count as the wrapped command being ready,
+	// other tokens are still written out as they're split.
+	Ready *regexp.Regexp
+
+	// Error, if set, marks the current chunk of data as rejected if an output token matches it.
+	Error *regexp.Regexp
+
+	// Success, if set, marks the current chunk of data as rejected unless an output token matches it.
+	// Wait returns a *throttler.Rejection for rejected chunks once the wrapped command is ready again,
+	// Stop returns it for the last chunk of data.
+	Success *regexp.Regexp
+
+	// Script, if set, is run for each chunk of data instead of writing the chunk and matching SplitFunc,
+	// Ready, Error and Success are ignored.
+	Script *Script
+
 	// Timeout indicates how long to wait for the wrapped command to output matching text.
 	// If <=0 then the throttler will wait indefinitely.
 	Timeout time.Duration
+
+	// UsePTY indicates whether the wrapped command should be run in a pseudo-terminal.
+	// The wrapped command's stdout and stderr are then both matched, MatchStderr is ignored.
+	UsePTY bool
+
+	// Echo indicates whether the pseudo-terminal should echo its input back to the output.
+	// Only used with UsePTY.
+	Echo bool
+
+	// Rows and Cols are the pseudo-terminal's window size.
+	// If either is unset then the size is taken from (and kept in sync with) this process' terminal, if any.
+	// Only used with UsePTY.
+	Rows, Cols uint16
+
+	// OnTimeout is what to do when Timeout is exceeded.
+	OnTimeout Policy
+
+	// Retries is how many times to retry or restart before giving up and aborting,
+	// only used if OnTimeout is Retry or Restart, at least one attempt is made.
+	Retries int
+
+	// Backoff is how long to wait before the first retry or restart, doubling on each subsequent attempt.
+	Backoff time.Duration
+
+	// Restart is whether to respawn the wrapped command if it exits before responding to all data written to it,
+	// including the last chunk of data once its input is closed.
+	// The respawned command's initial prompt is waited for before re-sending the chunk of data it didn't respond to.
+	Restart RestartPolicy
+
+	// MaxRestarts is how many times the wrapped command can be respawned after exiting, unlimited if <= 0.
+	MaxRestarts int
+
+	// RestartBackoff is how long to wait before respawning the wrapped command after it exited.
+	RestartBackoff time.Duration
+
+	// Stats, if set, keeps track of timeouts and restarts.
+	Stats *stats.Stats
+
+	// Header, if set, returns a header (e.g., a CSV header row) to write before the first chunk of data
+	// written to the wrapped command, including after each restart.
+	// The wrapped command isn't expected to respond to the header on its own.
+	Header func() []byte
 }
 
 // New instantiates an Expect throttler.
@@ -49,6 +132,8 @@ func New(opts Options) (*Expect, error) {
 		cmd:    exec.Command(opts.Command[0], opts.Command[1:]...),
 		stdout: os.Stdout,
 		stderr: os.Stderr,
+		log:    os.Stderr,
+		done:   make(chan struct{}),
 		found:  make(chan struct{}, 1),
 		errc:   make(chan error, 1),
 	}, nil
@@ -56,21 +141,53 @@ func New(opts Options) (*Expect, error) {
 
 // Expect is an output-based throttler.
 type Expect struct {
-	opts   Options
-	cmd    *exec.Cmd
-	r      io.ReadCloser
-	w      io.WriteCloser
-	s      *bufio.Scanner
-	tee    io.Writer
-	stdout io.Writer
-	stderr io.Writer
-	closed bool
-	done   chan struct{}
-	found  chan struct{}
-	errc   chan error
+	opts      Options
+	cmd       *exec.Cmd
+	r         io.ReadCloser
+	w         io.WriteCloser
+	s         *bufio.Scanner
+	tee       io.Writer
+	stdout    io.Writer
+	stderr    io.Writer
+	log       io.Writer
+	pty       *os.File
+	tty       *os.File
+	winch     chan os.Signal
+	group     bool
+	last      byte
+	cur       []byte
+	eof       bool
+	closed    bool
+	reaping   chan struct{}
+	reaped    bool
+	drained   bool
+	exitErr   error
+	restarts  int
+	procMu    sync.Mutex
+	scriptMu  sync.Mutex
+	started   bool
+	pending   bool
+	bufMu     sync.Mutex
+	buf       []byte
+	failure   string
+	succeeded bool
+	written   bool
+	headed    bool
+	done      chan struct{}
+	found     chan struct{}
+	errc      chan error
 }
 
 func (e *Expect) setupCmd() error {
+	if e.opts.UsePTY {
+		// The wrapped command leads its own session, and thus its own process group.
+		e.group = true
+		return e.setupPTY()
+	}
+	if e.killsGroup() {
+		setpgid(e.cmd)
+		e.group = true
+	}
 	var err error
 	if e.w, err = e.cmd.StdinPipe(); err != nil {
 		return err
@@ -91,62 +208,237 @@ func (e *Expect) setupCmd() error {
 	return nil
 }
 
+// killsGroup returns whether the wrapped command may need to be killed along with any processes it forked,
+// i.e., on timeout or to restart it, it's otherwise left in this process' group so that job control keeps working.
+func (e *Expect) killsGroup() bool {
+	return e.opts.Timeout > 0 && e.opts.OnTimeout != Skip || e.opts.Restart != RestartNever
+}
+
 // reader reads from the wrapped command's stdout/stderr,
-// writes the buffer to *this* command's corresponding stdout/stderr and notifies `found`,
-// notifies `errc` on error.
+// writes the buffer to *this* command's corresponding stdout/stderr and notifies `found` if it's ready,
+// notifies `errc` on error, closes `done` when there's nothing left to read.
 func (e *Expect) reader() {
+	defer close(e.done)
 	for e.s.Scan() {
 		b := e.s.Bytes()
 		if _, err := e.tee.Write(b); err != nil {
 			e.errc <- err
 			return
 		}
+		e.classify(b)
+		if e.opts.Ready != nil && !e.opts.Ready.Match(b) {
+			continue
+		}
 		e.found <- struct{}{}
 	}
 	e.errc <- e.s.Err()
 }
 
+// classify records whether an output token marks the current chunk of data as rejected or successful.
+func (e *Expect) classify(b []byte) {
+	e.bufMu.Lock()
+	defer e.bufMu.Unlock()
+	if e.opts.Error != nil && e.failure == "" && e.opts.Error.Match(b) {
+		e.failure = strings.TrimSpace(string(b))
+	}
+	if e.opts.Success != nil && e.opts.Success.Match(b) {
+		e.succeeded = true
+	}
+}
+
+// verdict returns a *throttler.Rejection if the chunk of data written since the last verdict was rejected,
+// nil otherwise, output from before the first chunk of data is disregarded.
+func (e *Expect) verdict() error {
+	e.bufMu.Lock()
+	failure, succeeded := e.failure, e.succeeded
+	e.failure, e.succeeded = "", false
+	written := e.written
+	e.written = false
+	e.bufMu.Unlock()
+	if !written {
+		return nil
+	}
+	if failure == "" && e.opts.Success != nil && !succeeded {
+		failure = "no output matched " + e.opts.Success.String()
+	}
+	if failure == "" {
+		return nil
+	}
+	return &throttler.Rejection{
+		Chunk:  append([]byte(nil), e.cur...),
+		Reason: failure,
+	}
+}
+
 // Start starts up the throttler.
 func (e *Expect) Start() error {
 	if err := e.setupCmd(); err != nil {
 		return err
 	}
-	e.s = bufio.NewScanner(e.r)
-	e.s.Split(e.opts.SplitFunc)
-	go e.reader()
-	return e.cmd.Start()
+	if e.opts.Script != nil {
+		go e.scriptReader()
+	} else {
+		max := e.maxChunkSize()
+		e.s = bufio.NewScanner(e.r)
+		e.s.Buffer(nil, max)
+		e.s.Split(split.WithMaxSize(e.opts.SplitFunc, e.opts.Delimiter, max, e.opts.Oversize, nil))
+		go e.reader()
+	}
+	if err := e.cmd.Start(); err != nil {
+		e.closePTY()
+		return err
+	}
+	if e.tty != nil {
+		// The wrapped command holds its own copy.
+		return e.tty.Close()
+	}
+	return nil
+}
+
+// maxChunkSize returns the maximum size of an output token.
+func (e *Expect) maxChunkSize() int {
+	if e.opts.MaxChunkSize <= 0 {
+		return bufio.MaxScanTokenSize
+	}
+	return e.opts.MaxChunkSize
+}
+
+// drain discards any pending matches until the reader is done,
+// this lets all of the wrapped command's output through, returns whether there were any matches.
+func drain(found <-chan struct{}, done <-chan struct{}) bool {
+	matched := false
+	for {
+		select {
+		case <-found:
+			matched = true
+		case <-done:
+			return matched
+		}
+	}
+}
+
+// reap waits for the wrapped command to exit and releases its resources,
+// returns the wrapped command's exit status, only the first call has any effect.
+// procMu isn't held while waiting so that the wrapped command's input can still be closed,
+// concurrent calls wait for the first one to be done.
+func (e *Expect) reap() error {
+	e.procMu.Lock()
+	if reaping := e.reaping; reaping != nil {
+		e.procMu.Unlock()
+		<-reaping
+		e.procMu.Lock()
+		defer e.procMu.Unlock()
+		return e.exitErr
+	}
+	reaping := make(chan struct{})
+	e.reaping = reaping
+	cmd, found, done := e.cmd, e.found, e.done
+	e.procMu.Unlock()
+	matched := drain(found, done)
+	err := cmd.Wait()
+	e.procMu.Lock()
+	defer e.procMu.Unlock()
+	e.exitErr = err
+	e.drained = matched
+	e.closePTY()
+	e.reaped = true
+	close(reaping)
+	return err
 }
 
 // Stop shuts down the throttler.
+// The restart policy is applied if the wrapped command exits without responding to the last chunk of data.
+// Returns a *throttler.Rejection if the last chunk of data was rejected and the wrapped command exited cleanly.
 func (e *Expect) Stop() error {
-	return e.cmd.Wait()
+	err := e.reap()
+	if e.unanswered(err) {
+		if err := e.respawn(err); err != nil {
+			return err
+		}
+		if err := e.resend(); err != nil {
+			return err
+		}
+		return e.Stop()
+	}
+	if err != nil {
+		return err
+	}
+	if e.opts.Script != nil {
+		return nil
+	}
+	return e.verdict()
 }
 
 // DoneRead indicates that there is no more data to be read into the throttler.
+// If there's a script then it's run for the last chunk of data first.
 func (e *Expect) DoneRead() error {
-	return e.w.Close()
+	if e.opts.Script != nil && e.isPending() {
+		if err := e.Wait(); err != nil {
+			return err
+		}
+	}
+	return e.closeInput()
 }
 
-// Wait blocks until the wrapped program's output matches the expected string
-// or the timeout is exceeded.
+// closeInput closes the wrapped command's input.
+func (e *Expect) closeInput() error {
+	e.procMu.Lock()
+	defer e.procMu.Unlock()
+	e.eof = true
+	if e.reaped {
+		// The wrapped command already exited and its input was closed along with it.
+		return nil
+	}
+	if e.pty == nil {
+		return e.w.Close()
+	}
+	// Closing the pseudo-terminal would hang up the wrapped command, send an end-of-file character instead.
+	// A pending partial line needs an additional one to be flushed first.
+	eof := []byte{ptyEOF}
+	if e.last != 0 && e.last != '\n' {
+		eof = append(eof, ptyEOF)
+	}
+	_, err := e.w.Write(eof)
+	return err
+}
+
+// Wait blocks until the wrapped program's output matches the expected string,
+// the timeout policy is applied if the timeout is exceeded
+// and the restart policy is applied if the wrapped program exits.
 func (e *Expect) Wait() error {
-	if e.closed {
+	err := e.wait()
+	if errors.Is(err, ErrTimeout) {
+		return e.onTimeout()
+	}
+	if e.isClosed() && !errors.Is(err, ErrClosed) {
+		return e.supervise(err)
+	}
+	return err
+}
+
+// wait blocks until the wrapped program's output matches the expected string
+// or the timeout is exceeded.
+func (e *Expect) wait() error {
+	if e.isClosed() {
 		return ErrClosed
 	}
+	if e.opts.Script != nil {
+		return e.waitScript()
+	}
 	if e.opts.Timeout <= 0 {
 		select {
 		case <-e.found:
-			return nil
+			return e.verdict()
 		case err := <-e.errc:
-			e.closed = true
+			e.setClosed()
 			return err
 		}
 	}
 	select {
 	case <-e.found:
-		return nil
+		return e.verdict()
 	case err := <-e.errc:
-		e.closed = true
+		e.setClosed()
 		return err
 	case <-time.After(e.opts.Timeout):
 		return ErrTimeout
@@ -154,6 +446,49 @@ func (e *Expect) Wait() error {
 }
 
 // Write writes the next chunk of data to the wrapped program's stdin.
+// The chunk is kept in case it needs to be re-sent after a timeout.
+// If there's a script then the chunk is only sent by the script, once Wait or DoneRead are called.
 func (e *Expect) Write(b []byte) (int, error) {
-	return e.w.Write(b)
+	e.cur = append(e.cur[:0], b...)
+	if e.opts.Script != nil {
+		e.scriptMu.Lock()
+		e.pending = true
+		e.scriptMu.Unlock()
+		return len(b), nil
+	}
+	e.bufMu.Lock()
+	e.written = true
+	e.bufMu.Unlock()
+	if len(b) > 0 {
+		e.last = b[len(b)-1]
+	}
+	n, err := e.send(b)
+	if err == nil || e.opts.Restart == RestartNever {
+		return n, err
+	}
+	// The wrapped command is unable to take any more input.
+	e.kill()
+	if err := e.respawn(err); err != nil {
+		return 0, err
+	}
+	return e.send(b)
+}
+
+// send writes a chunk of data to the wrapped command's stdin,
+// preceded by the header if it hasn't been written to this instance of the wrapped command yet.
+func (e *Expect) send(b []byte) (int, error) {
+	if e.headed || e.opts.Header == nil {
+		return e.w.Write(b)
+	}
+	h := e.opts.Header()
+	if h == nil {
+		return e.w.Write(b)
+	}
+	e.headed = true
+	n, err := e.w.Write(append(append([]byte(nil), h...), b...))
+	n -= len(h)
+	if n < 0 {
+		n = 0
+	}
+	return n, err
 }
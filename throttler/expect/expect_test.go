@@ -5,10 +5,13 @@ import (
 	"errors"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/hazaelsan/pipe-throttler/split"
+	"github.com/hazaelsan/pipe-throttler/stats"
+	"github.com/hazaelsan/pipe-throttler/throttler"
 )
 
 const (
@@ -23,16 +26,26 @@ var (
 )
 
 type appendWriter struct {
+	mu     sync.Mutex
 	s      []string
 	closed bool
 	err    error
 }
 
 func (a *appendWriter) Write(b []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.s = append(a.s, string(b))
 	return len(b), a.err
 }
 
+// get returns the ith write.
+func (a *appendWriter) get(i int) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.s[i]
+}
+
 func (a *appendWriter) Close() error {
 	if a.closed {
 		return errClosed
@@ -139,7 +152,7 @@ func TestWait(t *testing.T) {
 	}{
 		{
 			name: "closed",
-			f:    func(e *Expect) { e.closed = true },
+			f:    func(e *Expect) { e.setClosed() },
 			err:  ErrClosed,
 		},
 		{
@@ -170,7 +183,9 @@ func TestWait(t *testing.T) {
 		},
 	}
 	for _, tt := range testdata {
-		e, err := New(goodOpts(tt.d))
+		opts := goodOpts(tt.d)
+		opts.Stats = stats.New(0, 0)
+		e, err := New(opts)
 		if err != nil {
 			t.Errorf("New(%v) error = %v", tt.name, err)
 			continue
@@ -180,6 +195,9 @@ func TestWait(t *testing.T) {
 		if err := e.Wait(); !errors.Is(err, tt.err) {
 			t.Errorf("Wait(%v) error = %v, want %v", tt.name, err, tt.err)
 		}
+		if got, want := opts.Stats.Snapshot().Timeouts == 1, errors.Is(tt.err, ErrTimeout); got != want {
+			t.Errorf("Wait(%v) timeouts = %v", tt.name, opts.Stats.Snapshot().Timeouts)
+		}
 	}
 }
 
@@ -201,7 +219,7 @@ func TestReader(t *testing.T) {
 	for i := 0; i < len(want); i++ {
 		select {
 		case <-e.found:
-			got := e.tee.(*appendWriter).s[i]
+			got := e.tee.(*appendWriter).get(i)
 			if got != want[i] {
 				t.Errorf("Write(%v) = %q, want %q", i, got, want[i])
 			}
@@ -258,6 +276,27 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+func TestWrite_header(t *testing.T) {
+	var header []byte
+	opts := goodOpts(10 * time.Millisecond)
+	opts.Header = func() []byte { return header }
+	e, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	w := new(appendWriter)
+	e.w = w
+	for _, data := range []string{"early\n", "foo\n", "bar\n"} {
+		if _, err := e.Write([]byte(data)); err != nil {
+			t.Errorf("Write(%v) error = %v", data, err)
+		}
+		header = []byte("h\n")
+	}
+	if got, want := strings.Join(w.s, "|"), "early\n|h\nfoo\n|bar\n"; got != want {
+		t.Errorf("Write() = %q, want %q", got, want)
+	}
+}
+
 func TestExpect(t *testing.T) {
 	wantStdout := "stdout 1\nstdout 2\nstdout 3"
 	wantStderr := "stderr 1\nstderr 2\nstderr 3"
@@ -290,3 +329,117 @@ func TestExpect(t *testing.T) {
 		t.Errorf("stderr = %q, want %q", got, wantStderr)
 	}
 }
+
+func TestExpect_oversize(t *testing.T) {
+	opts := goodOpts(10 * time.Millisecond)
+	opts.Command = []string{"sh", "-c", "echo stdout 1; echo 0123456789abcdefghij; echo -n stdout 3"}
+	opts.MaxChunkSize = 16
+	opts.Oversize = split.OversizeTruncate
+	opts.Delimiter = split.REDelimiter(regexp.MustCompile("\n"))
+	e, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.stdout = new(strings.Builder)
+	if err := e.Start(); err != nil {
+		t.Errorf("Start() error = %v", err)
+	}
+	if err := e.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	if err := e.DoneRead(); err != nil {
+		t.Errorf("DoneRead() error = %v", err)
+	}
+	if err := e.Stop(); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+	want := "stdout 1\n0123456789abcdef\nstdout 3"
+	if got := e.stdout.(*strings.Builder).String(); got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+}
+
+func TestReady(t *testing.T) {
+	cmd := `echo ">"; while read l; do echo "info $l"; [ "$l" = bad ] && echo "ERROR $l"; echo ">"; done`
+	opts := goodOpts(time.Second)
+	opts.Command = []string{"sh", "-c", cmd}
+	opts.Ready = regexp.MustCompile("^>")
+	opts.Error = regexp.MustCompile("^ERROR")
+	e, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	stdout := new(syncBuffer)
+	e.stdout = stdout
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := e.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	testdata := []struct {
+		chunk string
+		err   error
+	}{
+		{chunk: "foo\n"},
+		{chunk: "bad\n", err: ErrRejected},
+		{chunk: "bar\n"},
+	}
+	for _, tt := range testdata {
+		if _, err := e.Write([]byte(tt.chunk)); err != nil {
+			t.Errorf("Write(%q) error = %v", tt.chunk, err)
+		}
+		if err := e.Wait(); !errors.Is(err, tt.err) {
+			t.Errorf("Wait(%q) error = %v, want %v", tt.chunk, err, tt.err)
+		}
+	}
+	if err := e.DoneRead(); err != nil {
+		t.Errorf("DoneRead() error = %v", err)
+	}
+	if err := e.Stop(); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+	want := ">\ninfo foo\n>\ninfo bad\nERROR bad\n>\ninfo bar\n>\n"
+	if got := string(stdout.take()); got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+}
+
+func TestSuccess(t *testing.T) {
+	cmd := `echo ">"; while read l; do [ "$l" = good ] && echo "OK $l"; echo ">"; done`
+	opts := goodOpts(time.Second)
+	opts.Command = []string{"sh", "-c", cmd}
+	opts.Ready = regexp.MustCompile("^>")
+	opts.Success = regexp.MustCompile("^OK")
+	e, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	e.stdout = new(syncBuffer)
+	if err := e.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := e.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	if _, err := e.Write([]byte("good\n")); err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+	if err := e.Wait(); err != nil {
+		t.Errorf("Wait() error = %v", err)
+	}
+	// The last chunk of data is only classified once the throttler stops.
+	if _, err := e.Write([]byte("bad\n")); err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+	if err := e.DoneRead(); err != nil {
+		t.Errorf("DoneRead() error = %v", err)
+	}
+	var rej *throttler.Rejection
+	if err := e.Stop(); !errors.As(err, &rej) {
+		t.Fatalf("Stop() error = %v, want rejection", err)
+	}
+	if got, want := string(rej.Chunk), "bad\n"; got != want {
+		t.Errorf("Stop() rejected %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,363 @@
+package expect
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/hazaelsan/pipe-throttler/internal/syncio"
+	"github.com/hazaelsan/pipe-throttler/throttler"
+)
+
+var (
+	// ErrNoWorkers is returned when a pool has no workers.
+	ErrNoWorkers = errors.New("no workers")
+
+	// ErrNotReady is returned when writing to a pool without waiting for a ready worker.
+	ErrNotReady = errors.New("no worker ready")
+)
+
+// NewPool instantiates a Pool throttler running workers copies of the wrapped command.
+// If ordered is set then the wrapped commands' matched output is written in the same order as their input,
+// otherwise it's written as soon as it's available.
+func NewPool(opts Options, workers int, ordered bool) (*Pool, error) {
+	if workers < 1 {
+		return nil, ErrNoWorkers
+	}
+	p := &Pool{
+		ordered:  ordered,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		ready:    make(chan ready, workers),
+		draining: make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		e, err := New(opts)
+		if err != nil {
+			return nil, err
+		}
+		p.workers = append(p.workers, &worker{
+			e:       e,
+			seq:     -1,
+			written: make(chan struct{}, 1),
+		})
+	}
+	return p, nil
+}
+
+// A Pool is an output-based throttler that dispatches data to multiple copies of a wrapped command.
+// Each chunk of data is written to whichever wrapped command is ready first.
+type Pool struct {
+	workers  []*worker
+	ordered  bool
+	stdout   io.Writer
+	stderr   io.Writer
+	seq      *sequencer
+	cur      *worker
+	next     int
+	ready    chan ready
+	draining chan struct{}
+	drained  sync.Once
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	late     throttler.Rejections
+}
+
+// A worker is a single wrapped command in a Pool.
+type worker struct {
+	e       *Expect
+	buf     *syncBuffer
+	seq     int
+	written chan struct{}
+}
+
+// ready indicates that a worker is ready for more data, or that waiting for it failed.
+type ready struct {
+	w   *worker
+	err error
+}
+
+// complete hands a worker's buffered output for its current chunk of data over to the sequencer,
+// output not belonging to any chunk of data is written out immediately.
+func (p *Pool) complete(w *worker) error {
+	seq := w.seq
+	w.seq = -1
+	if w.buf == nil {
+		return nil
+	}
+	return p.seq.add(seq, w.buf.take())
+}
+
+// reject keeps track of chunks of data rejected after the pool has been drained, nil errors are ignored.
+// Returns false if err isn't a rejection.
+func (p *Pool) reject(err error) bool {
+	var rej *throttler.Rejection
+	if !errors.As(err, &rej) {
+		return err == nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.late = append(p.late, rej)
+	return true
+}
+
+// run waits for a worker to become ready each time it's written to,
+// until it fails or the pool is drained.
+// A worker is still ready after rejecting a chunk of data.
+func (p *Pool) run(w *worker) {
+	defer p.wg.Done()
+	for {
+		err := w.e.Wait()
+		if cErr := p.complete(w); err == nil {
+			err = cErr
+		}
+		select {
+		case p.ready <- ready{w, err}:
+		case <-p.draining:
+			p.reject(err)
+			return
+		}
+		if err != nil && !errors.Is(err, ErrRejected) {
+			return
+		}
+		select {
+		case <-w.written:
+		case <-p.draining:
+			// The last chunk of data may have been written right before draining.
+			select {
+			case <-w.written:
+			default:
+				return
+			}
+		}
+	}
+}
+
+// Start starts up the throttler.
+func (p *Pool) Start() error {
+	stdout := syncio.NewWriter(p.stdout)
+	stderr := syncio.NewWriter(p.stderr)
+	if p.ordered {
+		p.seq = newSequencer(stdout)
+		if p.workers[0].e.opts.MatchStderr && !p.workers[0].e.opts.UsePTY {
+			p.seq = newSequencer(stderr)
+		}
+	}
+	for _, w := range p.workers {
+		w.e.stdout = stdout
+		w.e.stderr = stderr
+		if p.ordered {
+			w.buf = new(syncBuffer)
+			if p.seq.w == stderr {
+				w.e.stderr = w.buf
+			} else {
+				w.e.stdout = w.buf
+			}
+		}
+		if err := w.e.Start(); err != nil {
+			return err
+		}
+		p.wg.Add(1)
+		go p.run(w)
+	}
+	return nil
+}
+
+// Stop shuts down the throttler.
+// Returns any rejections for the last chunks of data written to each worker if there are no other errors.
+func (p *Pool) Stop() error {
+	err := p.stop()
+	if err != nil {
+		return err
+	}
+	// Workers may have become ready after the last chunk of data was written.
+	for len(p.ready) > 0 {
+		p.reject((<-p.ready).err)
+	}
+	switch len(p.late) {
+	case 0:
+		return nil
+	case 1:
+		return p.late[0]
+	}
+	return p.late
+}
+
+func (p *Pool) stop() error {
+	// The workers must be done waiting before their wrapped commands are stopped,
+	// they return once the pool is drained and their wrapped commands respond to their last chunk of data or exit.
+	p.drain()
+	p.wg.Wait()
+	var err error
+	for _, w := range p.workers {
+		if wErr := w.e.Stop(); !p.reject(wErr) && err == nil {
+			err = wErr
+		}
+	}
+	if !p.ordered {
+		return err
+	}
+	for _, w := range p.workers {
+		if w.seq < 0 {
+			continue
+		}
+		if wErr := p.complete(w); err == nil {
+			err = wErr
+		}
+	}
+	if wErr := p.seq.flush(); err == nil {
+		err = wErr
+	}
+	// Anything left was output after the last chunk of data.
+	for _, w := range p.workers {
+		if wErr := p.complete(w); err == nil {
+			err = wErr
+		}
+	}
+	return err
+}
+
+// drain tells the workers to return instead of waiting for more data.
+func (p *Pool) drain() {
+	p.drained.Do(func() { close(p.draining) })
+}
+
+// DoneRead indicates that there is no more data to be read into the throttler.
+func (p *Pool) DoneRead() error {
+	p.drain()
+	if p.workers[0].e.opts.Script != nil {
+		// Scripts for the last chunks of data are still being run by the workers.
+		p.wg.Wait()
+	}
+	var err error
+	for _, w := range p.workers {
+		if wErr := w.e.closeInput(); err == nil {
+			err = wErr
+		}
+	}
+	return err
+}
+
+// Wait blocks until any of the wrapped commands is ready,
+// returns a *throttler.Rejection if it rejected its last chunk of data, it can still be written to.
+func (p *Pool) Wait() error {
+	r := <-p.ready
+	if r.err != nil && !errors.Is(r.err, ErrRejected) {
+		return r.err
+	}
+	p.cur = r.w
+	return r.err
+}
+
+// Write writes the next chunk of data to the wrapped command that was last found to be ready.
+func (p *Pool) Write(b []byte) (int, error) {
+	w := p.cur
+	if w == nil {
+		return 0, ErrNotReady
+	}
+	p.cur = nil
+	if p.ordered {
+		w.seq = p.next
+		p.next++
+	}
+	n, err := w.e.Write(b)
+	w.written <- struct{}{}
+	return n, err
+}
+
+// KillGroup kills the wrapped commands running in their own process groups, see Expect.KillGroup.
+func (p *Pool) KillGroup() {
+	for _, w := range p.workers {
+		w.e.KillGroup()
+	}
+}
+
+// A sequencer writes out buffers in sequence order.
+type sequencer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	next    int
+	pending map[int][]byte
+}
+
+func newSequencer(w io.Writer) *sequencer {
+	return &sequencer{
+		w:       w,
+		pending: make(map[int][]byte),
+	}
+}
+
+// add adds the buffer for a sequence number and writes out all buffers that are now in order,
+// buffers for negative sequence numbers are written out immediately.
+func (s *sequencer) add(seq int, b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq < 0 {
+		if len(b) == 0 {
+			return nil
+		}
+		_, err := s.w.Write(b)
+		return err
+	}
+	s.pending[seq] = append(s.pending[seq], b...)
+	for {
+		b, ok := s.pending[s.next]
+		if !ok {
+			return nil
+		}
+		delete(s.pending, s.next)
+		s.next++
+		if len(b) == 0 {
+			continue
+		}
+		if _, err := s.w.Write(b); err != nil {
+			return err
+		}
+	}
+}
+
+// flush writes out all remaining buffers in order, regardless of any gaps in the sequence.
+func (s *sequencer) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var seqs []int
+	for seq := range s.pending {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	for _, seq := range seqs {
+		b := s.pending[seq]
+		delete(s.pending, seq)
+		if len(b) == 0 {
+			continue
+		}
+		if _, err := s.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A syncBuffer is a buffer that's safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(b)
+}
+
+// take returns and resets the buffer's contents.
+func (s *syncBuffer) take() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := append([]byte(nil), s.buf.Bytes()...)
+	s.buf.Reset()
+	return b
+}
@@ -0,0 +1,172 @@
+package expect
+
+import (
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/split"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+const poolCmd = "echo ready; while read d l; do sleep $d; echo $l; done"
+
+func poolOpts() Options {
+	return Options{
+		Command:   []string{"sh", "-c", poolCmd},
+		Timeout:   time.Second,
+		SplitFunc: split.ByRE(regexp.MustCompile("\n")),
+	}
+}
+
+func TestNewPool(t *testing.T) {
+	testdata := []struct {
+		name    string
+		opts    Options
+		workers int
+		err     error
+	}{
+		{
+			name:    "good",
+			opts:    poolOpts(),
+			workers: 2,
+		},
+		{
+			name:    "no workers",
+			opts:    poolOpts(),
+			workers: 0,
+			err:     ErrNoWorkers,
+		},
+		{
+			name:    "no command",
+			workers: 2,
+			err:     ErrNoCommand,
+		},
+	}
+	for _, tt := range testdata {
+		if _, err := NewPool(tt.opts, tt.workers, false); !errors.Is(err, tt.err) {
+			t.Errorf("NewPool(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	input := []string{"0.2 a\n", "0 b\n", "0 c\n", "0 d\n"}
+	testdata := []struct {
+		name    string
+		ordered bool
+		want    []string
+	}{
+		{
+			name: "unordered",
+			want: []string{"b", "c", "d", "a"},
+		},
+		{
+			name:    "ordered",
+			ordered: true,
+			want:    []string{"a", "b", "c", "d"},
+		},
+	}
+	for _, tt := range testdata {
+		p, err := NewPool(poolOpts(), 2, tt.ordered)
+		if err != nil {
+			t.Fatalf("NewPool(%v) error = %v", tt.name, err)
+		}
+		stdout := new(strings.Builder)
+		p.stdout = stdout
+		if err := p.Start(); err != nil {
+			t.Fatalf("Start(%v) error = %v", tt.name, err)
+		}
+		for _, s := range input {
+			if err := p.Wait(); err != nil {
+				t.Errorf("Wait(%v) error = %v", tt.name, err)
+			}
+			if _, err := p.Write([]byte(s)); err != nil {
+				t.Errorf("Write(%v, %q) error = %v", tt.name, s, err)
+			}
+		}
+		if err := p.DoneRead(); err != nil {
+			t.Errorf("DoneRead(%v) error = %v", tt.name, err)
+		}
+		if err := p.Stop(); err != nil {
+			t.Errorf("Stop(%v) error = %v", tt.name, err)
+		}
+		got := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+		if len(got) < 2 || got[0] != "ready" || got[1] != "ready" {
+			t.Errorf("stdout(%v) = %q, want two ready lines first", tt.name, got)
+			continue
+		}
+		got = got[2:]
+		if diff := pretty.Compare(got, tt.want); diff != "" {
+			t.Errorf("stdout(%v) -got +want:\n%v", tt.name, diff)
+		}
+		sort.Strings(got)
+		if diff := pretty.Compare(got, []string{"a", "b", "c", "d"}); diff != "" {
+			t.Errorf("stdout(%v) -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestPool_notReady(t *testing.T) {
+	p, err := NewPool(poolOpts(), 1, false)
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	if _, err := p.Write([]byte("foo")); !errors.Is(err, ErrNotReady) {
+		t.Errorf("Write() error = %v, want %v", err, ErrNotReady)
+	}
+}
+
+func TestSequencer(t *testing.T) {
+	w := new(strings.Builder)
+	s := newSequencer(w)
+	for _, seq := range []int{2, -1, 0, 4, 1} {
+		if err := s.add(seq, []byte(strings.Repeat("x", seq+1)+"\n")); err != nil {
+			t.Errorf("add(%v) error = %v", seq, err)
+		}
+	}
+	if err := s.flush(); err != nil {
+		t.Errorf("flush() error = %v", err)
+	}
+	want := "\nx\nxx\nxxx\nxxxxx\n"
+	if got := w.String(); got != want {
+		t.Errorf("sequencer = %q, want %q", got, want)
+	}
+}
+
+func TestPool_restart(t *testing.T) {
+	// The wrapped commands exit without responding, they're being restarted as the pool is drained and stopped.
+	opts := poolOpts()
+	opts.Command = []string{"sh", "-c", "echo ready; read l; sleep 0.05; exit 1"}
+	opts.Restart = RestartAlways
+	opts.MaxRestarts = 3
+	opts.RestartBackoff = time.Millisecond
+	p, err := NewPool(opts, 2, true)
+	if err != nil {
+		t.Fatalf("NewPool() error = %v", err)
+	}
+	p.stdout = new(strings.Builder)
+	for _, w := range p.workers {
+		w.e.log = ioutil.Discard
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	for _, s := range []string{"a\n", "b\n"} {
+		if err := p.Wait(); err != nil {
+			t.Fatalf("Wait(%q) error = %v", s, err)
+		}
+		if _, err := p.Write([]byte(s)); err != nil {
+			t.Errorf("Write(%q) error = %v", s, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := p.DoneRead(); err != nil {
+		t.Errorf("DoneRead() error = %v", err)
+	}
+	p.Stop()
+}
@@ -0,0 +1,17 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package expect
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setpgid is a no-op on platforms without process groups.
+func setpgid(*exec.Cmd) {}
+
+// killGroup kills a process, processes it forked are left running on platforms without process groups.
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
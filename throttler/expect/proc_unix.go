@@ -0,0 +1,20 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package expect
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setpgid runs a command in its own process group so that it can be killed along with any processes it forks.
+func setpgid(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killGroup kills a process and the rest of its process group.
+func killGroup(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
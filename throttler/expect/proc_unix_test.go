@@ -0,0 +1,93 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package expect
+
+import (
+	"bufio"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestKillGroup(t *testing.T) {
+	testdata := []struct {
+		name    string
+		timeout time.Duration
+		policy  Policy
+		restart RestartPolicy
+		group   bool
+	}{
+		{
+			name: "no timeout",
+		},
+		{
+			name:    "timeout",
+			timeout: time.Hour,
+			group:   true,
+		},
+		{
+			name:    "skip",
+			timeout: time.Hour,
+			policy:  Skip,
+		},
+		{
+			name:    "restart",
+			restart: RestartOnFailure,
+			group:   true,
+		},
+	}
+	for _, tt := range testdata {
+		e, err := New(Options{
+			Command:   []string{"sh", "-c", "sleep 300 & echo $!; wait"},
+			Timeout:   tt.timeout,
+			OnTimeout: tt.policy,
+			Restart:   tt.restart,
+		})
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		// The wrapped command's output is read directly, the pid of the process it forked.
+		e.setupCmd()
+		if err := e.cmd.Start(); err != nil {
+			t.Fatalf("Start(%v) error = %v", tt.name, err)
+		}
+		line, err := bufio.NewReader(e.r).ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString(%v) error = %v", tt.name, err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil {
+			t.Fatalf("Atoi(%v) error = %v", tt.name, err)
+		}
+		pgid, err := syscall.Getpgid(pid)
+		if err != nil {
+			t.Fatalf("Getpgid(%v) error = %v", tt.name, err)
+		}
+		if got := pgid != syscall.Getpgrp(); got != tt.group {
+			t.Errorf("own process group(%v) = %v, want %v", tt.name, got, tt.group)
+		}
+		e.KillGroup()
+		// The wrapped command's output is closed once it and the process it forked are gone.
+		done := make(chan struct{})
+		go func() {
+			ioutil.ReadAll(e.r)
+			close(done)
+		}()
+		killed := true
+		select {
+		case <-done:
+		case <-time.After(500 * time.Millisecond):
+			killed = false
+		}
+		if killed != tt.group {
+			t.Errorf("KillGroup(%v) killed = %v, want %v", tt.name, killed, tt.group)
+		}
+		syscall.Kill(pid, syscall.SIGKILL)
+		<-done
+		e.cmd.Wait()
+	}
+}
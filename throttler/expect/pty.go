@@ -0,0 +1,42 @@
+package expect
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ptyEOF is the end-of-file character in canonical mode (^D).
+const ptyEOF = 0x04
+
+// ptyReader is a reader for the master side of a pseudo-terminal.
+type ptyReader struct {
+	*os.File
+}
+
+// Read reads from the pseudo-terminal,
+// the error returned once all processes have closed the slave side is mapped to io.EOF.
+func (p ptyReader) Read(b []byte) (int, error) {
+	n, err := p.File.Read(b)
+	if errors.Is(err, syscall.EIO) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// closePTY releases any pseudo-terminal resources.
+func (e *Expect) closePTY() {
+	if e.winch != nil {
+		signal.Stop(e.winch)
+		close(e.winch)
+		e.winch = nil
+	}
+	if e.tty != nil {
+		e.tty.Close()
+	}
+	if e.pty != nil {
+		e.pty.Close()
+	}
+}
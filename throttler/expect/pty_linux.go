@@ -0,0 +1,152 @@
+//go:build linux
+// +build linux
+
+package expect
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// winsize is the kernel's struct winsize.
+type winsize struct {
+	rows, cols, xpixel, ypixel uint16
+}
+
+func ioctl(f *os.File, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openPTY allocates a pseudo-terminal, returns its master and slave sides.
+func openPTY() (*os.File, *os.File, error) {
+	pty, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var n uint32
+	if err := ioctl(pty, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("TIOCGPTN: %w", err)
+	}
+	var unlock int32
+	if err := ioctl(pty, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		pty.Close()
+		return nil, nil, fmt.Errorf("TIOCSPTLCK: %w", err)
+	}
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		pty.Close()
+		return nil, nil, err
+	}
+	return pty, tty, nil
+}
+
+// setEcho turns input echoing on/off for a terminal.
+func setEcho(f *os.File, echo bool) error {
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		return fmt.Errorf("TCGETS: %w", err)
+	}
+	if echo {
+		t.Lflag |= syscall.ECHO
+	} else {
+		t.Lflag &^= syscall.ECHO
+	}
+	if err := ioctl(f, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		return fmt.Errorf("TCSETS: %w", err)
+	}
+	return nil
+}
+
+func getWinsize(f *os.File) (*winsize, error) {
+	ws := new(winsize)
+	if err := ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(ws)); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func setWinsize(f *os.File, ws *winsize) error {
+	return ioctl(f, syscall.TIOCSWINSZ, unsafe.Pointer(ws))
+}
+
+// termWinsize returns the window size of the first of files that's a terminal,
+// or of this process' controlling terminal if none are.
+func termWinsize(files ...*os.File) (*winsize, error) {
+	for _, f := range files {
+		if ws, err := getWinsize(f); err == nil {
+			return ws, nil
+		}
+	}
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+	return getWinsize(tty)
+}
+
+// stdWinsize returns the window size of this process' terminal, if any.
+// Stdin is the data being throttled, so it's never a terminal worth asking.
+func stdWinsize() (*winsize, error) {
+	return termWinsize(os.Stdout, os.Stderr)
+}
+
+// syncWinsize copies the window size from this process' terminal to the pseudo-terminal every time it changes,
+// until winch is closed.
+func (e *Expect) syncWinsize(winch <-chan os.Signal) {
+	for range winch {
+		ws, err := stdWinsize()
+		if err != nil {
+			continue
+		}
+		e.procMu.Lock()
+		if e.pty != nil {
+			setWinsize(e.pty, ws)
+		}
+		e.procMu.Unlock()
+	}
+}
+
+// setupPTY sets up the wrapped command to run in a pseudo-terminal.
+func (e *Expect) setupPTY() error {
+	pty, tty, err := openPTY()
+	if err != nil {
+		return err
+	}
+	e.pty = pty
+	e.tty = tty
+	if err := setEcho(tty, e.opts.Echo); err != nil {
+		e.closePTY()
+		return err
+	}
+	if e.opts.Rows > 0 && e.opts.Cols > 0 {
+		err = setWinsize(pty, &winsize{rows: e.opts.Rows, cols: e.opts.Cols})
+	} else if ws, wsErr := stdWinsize(); wsErr == nil {
+		err = setWinsize(pty, ws)
+		e.winch = make(chan os.Signal, 1)
+		signal.Notify(e.winch, syscall.SIGWINCH)
+		go e.syncWinsize(e.winch)
+	}
+	if err != nil {
+		e.closePTY()
+		return fmt.Errorf("TIOCSWINSZ: %w", err)
+	}
+	e.cmd.Stdin = tty
+	e.cmd.Stdout = tty
+	e.cmd.Stderr = tty
+	e.cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:  true,
+		Setctty: true,
+	}
+	e.r = ptyReader{pty}
+	e.w = pty
+	e.tee = e.stdout
+	return nil
+}
@@ -0,0 +1,113 @@
+//go:build linux
+// +build linux
+
+package expect
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/split"
+)
+
+func TestPTY(t *testing.T) {
+	testdata := []struct {
+		name  string
+		cmd   string
+		echo  bool
+		input []string
+		want  string
+	}{
+		{
+			name:  "isatty",
+			cmd:   "test -t 0 && test -t 1 && test -t 2 && echo tty; read x; echo got $x",
+			input: []string{"hello\n"},
+			want:  "tty\r\ngot hello\r\n",
+		},
+		{
+			name:  "echo",
+			cmd:   "echo ready; read x; echo got $x",
+			echo:  true,
+			input: []string{"hello\n"},
+			want:  "ready\r\nhello\r\ngot hello\r\n",
+		},
+		{
+			name:  "window size",
+			cmd:   "stty size",
+			input: nil,
+			want:  "12 34\r\n",
+		},
+		{
+			name:  "eof",
+			cmd:   "echo ready; cat; echo done",
+			input: []string{"foo"},
+			want:  "ready\r\nfoodone\r\n",
+		},
+	}
+	for _, tt := range testdata {
+		e, err := New(Options{
+			Command:   []string{"sh", "-c", tt.cmd},
+			SplitFunc: split.ByRE(regexp.MustCompile("\n")),
+			Timeout:   time.Second,
+			UsePTY:    true,
+			Echo:      tt.echo,
+			Rows:      12,
+			Cols:      34,
+		})
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		stdout := new(strings.Builder)
+		e.stdout = stdout
+		if err := e.Start(); err != nil {
+			t.Fatalf("Start(%v) error = %v", tt.name, err)
+		}
+		for _, s := range tt.input {
+			if err := e.Wait(); err != nil {
+				t.Errorf("Wait(%v) error = %v", tt.name, err)
+			}
+			if _, err := e.Write([]byte(s)); err != nil {
+				t.Errorf("Write(%v) error = %v", tt.name, err)
+			}
+		}
+		if err := e.DoneRead(); err != nil {
+			t.Errorf("DoneRead(%v) error = %v", tt.name, err)
+		}
+		if err := e.Stop(); err != nil {
+			t.Errorf("Stop(%v) error = %v", tt.name, err)
+		}
+		if got := stdout.String(); got != tt.want {
+			t.Errorf("stdout(%v) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTermWinsize(t *testing.T) {
+	pty, tty, err := openPTY()
+	if err != nil {
+		t.Fatalf("openPTY() error = %v", err)
+	}
+	defer pty.Close()
+	defer tty.Close()
+	want := winsize{rows: 56, cols: 78}
+	if err := setWinsize(pty, &want); err != nil {
+		t.Fatalf("setWinsize() error = %v", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+	// Pipes aren't terminals and are skipped.
+	ws, err := termWinsize(r, tty)
+	if err != nil {
+		t.Fatalf("termWinsize() error = %v", err)
+	}
+	if *ws != want {
+		t.Errorf("termWinsize() = %+v, want %+v", *ws, want)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package expect
+
+// setupPTY is not supported on this platform.
+func (e *Expect) setupPTY() error {
+	return ErrNoPTY
+}
@@ -0,0 +1,150 @@
+package expect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrMaxRestarts is returned when the wrapped command exits after it has already been restarted too many times.
+var ErrMaxRestarts = errors.New("too many restarts")
+
+// A RestartPolicy is whether to respawn the wrapped command when it exits before responding to all data written to it.
+type RestartPolicy int
+
+const (
+	// RestartNever never respawns the wrapped command.
+	RestartNever RestartPolicy = iota
+
+	// RestartOnFailure respawns the wrapped command if it exits with a non-zero status or is killed,
+	// or if writing to it fails.
+	RestartOnFailure
+
+	// RestartAlways respawns the wrapped command whenever it exits.
+	RestartAlways
+)
+
+var restartPolicies = map[string]RestartPolicy{
+	"no":         RestartNever,
+	"on-failure": RestartOnFailure,
+	"always":     RestartAlways,
+}
+
+// ParseRestartPolicy parses a restart policy by name.
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	p, ok := restartPolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrBadPolicy, s)
+	}
+	return p, nil
+}
+
+func (p RestartPolicy) String() string {
+	for s, v := range restartPolicies {
+		if v == p {
+			return s
+		}
+	}
+	return fmt.Sprintf("RestartPolicy(%d)", int(p))
+}
+
+// exited indicates whether the wrapped command should be respawned after it exited,
+// err is the error returned while reading its output, if any.
+// A script left unfinished by the wrapped command exiting doesn't count as a failure in itself, its exit status does.
+func (e *Expect) exited(err error) bool {
+	if e.opts.Restart == RestartNever || e.inputClosed() {
+		return false
+	}
+	exitErr := e.reap()
+	failed := err != nil && !errors.Is(err, io.ErrUnexpectedEOF)
+	return e.opts.Restart == RestartAlways || failed || exitErr != nil
+}
+
+// isClosed indicates whether there's nothing left to read from the wrapped command's output.
+func (e *Expect) isClosed() bool {
+	e.procMu.Lock()
+	defer e.procMu.Unlock()
+	return e.closed
+}
+
+// setClosed records that there's nothing left to read from the wrapped command's output.
+func (e *Expect) setClosed() {
+	e.procMu.Lock()
+	defer e.procMu.Unlock()
+	e.closed = true
+}
+
+// unanswered indicates whether the wrapped command should be respawned after it exited,
+// once its input was closed, without responding to the last chunk of data,
+// exitErr is its exit status.
+// Scripts are run for the last chunk of data before closing the wrapped command's input, see DoneRead.
+func (e *Expect) unanswered(exitErr error) bool {
+	if e.opts.Restart == RestartNever || e.opts.Script != nil {
+		return false
+	}
+	e.bufMu.Lock()
+	written := e.written
+	e.bufMu.Unlock()
+	e.procMu.Lock()
+	drained := e.drained
+	e.procMu.Unlock()
+	if !written || drained {
+		return false
+	}
+	return e.opts.Restart == RestartAlways || exitErr != nil
+}
+
+// inputClosed returns whether the wrapped command's input was closed after the last chunk of data.
+func (e *Expect) inputClosed() bool {
+	e.procMu.Lock()
+	defer e.procMu.Unlock()
+	return e.eof
+}
+
+// respawn restarts the wrapped command after it exited or couldn't be written to,
+// waiting for its initial prompt if there's a chunk of data to re-send.
+// err is returned if the wrapped command has already been restarted too many times.
+func (e *Expect) respawn(err error) error {
+	for {
+		if e.opts.MaxRestarts > 0 && e.restarts >= e.opts.MaxRestarts {
+			fmt.Fprintf(e.log, "%v exited, giving up after %v restarts\n", e.opts.Command[0], e.restarts)
+			if err == nil {
+				err = ErrMaxRestarts
+			}
+			return err
+		}
+		e.restarts++
+		fmt.Fprintf(e.log, "%v exited (%v), restarting (%v)\n", e.opts.Command[0], e.reap(), e.restarts)
+		time.Sleep(e.opts.RestartBackoff)
+		if err := e.restart(); err != nil {
+			return err
+		}
+		if e.cur == nil {
+			return nil
+		}
+		err = e.wait()
+		if errors.Is(err, ErrTimeout) {
+			e.kill()
+			return err
+		}
+		if !e.isClosed() {
+			return nil
+		}
+	}
+}
+
+// supervise respawns the wrapped command if it exited while waiting for its output,
+// then re-sends the chunk of data it didn't respond to and waits again.
+func (e *Expect) supervise(err error) error {
+	if !e.exited(err) {
+		return err
+	}
+	if err := e.respawn(err); err != nil {
+		return err
+	}
+	if err := e.resend(); err != nil {
+		return err
+	}
+	return e.Wait()
+}
@@ -0,0 +1,230 @@
+package expect
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/split"
+	"github.com/hazaelsan/pipe-throttler/stats"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	testdata := []struct {
+		s    string
+		want RestartPolicy
+		err  error
+	}{
+		{s: "no", want: RestartNever},
+		{s: "on-failure", want: RestartOnFailure},
+		{s: "always", want: RestartAlways},
+		{s: "bogus", err: ErrBadPolicy},
+	}
+	for _, tt := range testdata {
+		got, err := ParseRestartPolicy(tt.s)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseRestartPolicy(%v) error = %v, want %v", tt.s, err, tt.err)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("ParseRestartPolicy(%v) = %v, want %v", tt.s, got, tt.want)
+		}
+		if err == nil && got.String() != tt.s {
+			t.Errorf("String(%v) = %v", tt.s, got)
+		}
+	}
+}
+
+func TestSupervise(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// crash exits without responding to its first chunk of data, the respawned command responds normally.
+	crash := `echo ready; read l; [ -e "$F" ] && { rm "$F"; echo "$l"; read l; echo "$l"; exit 0; }; touch "$F"; exit %v`
+	testdata := []struct {
+		name        string
+		script      string
+		policy      RestartPolicy
+		maxRestarts int
+		err         error
+		stdout      string
+		restarts    int64
+	}{
+		{
+			name:   "no restart",
+			script: `echo ready; read l; exit 1`,
+			policy: RestartNever,
+			stdout: "ready\n",
+		},
+		{
+			name:     "on failure",
+			script:   fmt.Sprintf(crash, 1),
+			policy:   RestartOnFailure,
+			stdout:   "ready\nready\nfoo\nbar\n",
+			restarts: 1,
+		},
+		{
+			name:   "on failure clean exit",
+			script: fmt.Sprintf(crash, 0),
+			policy: RestartOnFailure,
+			stdout: "ready\n",
+		},
+		{
+			name:     "always",
+			script:   fmt.Sprintf(crash, 0),
+			policy:   RestartAlways,
+			stdout:   "ready\nready\nfoo\nbar\n",
+			restarts: 1,
+		},
+		{
+			name:        "max restarts",
+			script:      `echo ready; read l; exit 1`,
+			policy:      RestartOnFailure,
+			maxRestarts: 2,
+			err:         ErrMaxRestarts,
+			stdout:      "ready\nready\nready\n",
+			restarts:    2,
+		},
+	}
+	for _, tt := range testdata {
+		opts := Options{
+			Command:        []string{"sh", "-c", tt.script},
+			SplitFunc:      split.ByRE(regexp.MustCompile("\n")),
+			Timeout:        time.Second,
+			Restart:        tt.policy,
+			MaxRestarts:    tt.maxRestarts,
+			RestartBackoff: time.Millisecond,
+			Stats:          stats.New(0, 0),
+		}
+		os.Setenv("F", filepath.Join(dir, tt.name))
+		e, err := New(opts)
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		stdout := new(syncBuffer)
+		e.stdout = stdout
+		e.log = ioutil.Discard
+		if err := e.Start(); err != nil {
+			t.Fatalf("Start(%v) error = %v", tt.name, err)
+		}
+		err = e.Wait()
+		for _, chunk := range []string{"foo\n", "bar\n"} {
+			if err != nil || e.isClosed() {
+				break
+			}
+			if _, err = e.Write([]byte(chunk)); err != nil {
+				break
+			}
+			err = e.Wait()
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Wait(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		e.DoneRead()
+		e.Stop()
+		if got := string(stdout.take()); got != tt.stdout {
+			t.Errorf("stdout(%v) = %q, want %q", tt.name, got, tt.stdout)
+		}
+		if got := opts.Stats.Snapshot().Restarts; got != tt.restarts {
+			t.Errorf("Stats(%v) restarts = %v, want %v", tt.name, got, tt.restarts)
+		}
+	}
+}
+
+func TestSupervise_lastChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// crash exits without responding to the last chunk of data, the respawned command responds normally.
+	crash := `echo ready; read l; [ -e "$F" ] || { touch "$F"; exit %v; }; echo "$l"; cat`
+	testdata := []struct {
+		name     string
+		script   string
+		policy   RestartPolicy
+		err      bool
+		stdout   string
+		restarts int64
+	}{
+		{
+			name:   "no restart",
+			script: fmt.Sprintf(crash, 3),
+			policy: RestartNever,
+			err:    true,
+			stdout: "ready\n",
+		},
+		{
+			name:     "on failure",
+			script:   fmt.Sprintf(crash, 3),
+			policy:   RestartOnFailure,
+			stdout:   "ready\nready\nfoo\n",
+			restarts: 1,
+		},
+		{
+			name:   "on failure clean exit",
+			script: fmt.Sprintf(crash, 0),
+			policy: RestartOnFailure,
+			stdout: "ready\n",
+		},
+		{
+			name:     "always",
+			script:   fmt.Sprintf(crash, 0),
+			policy:   RestartAlways,
+			stdout:   "ready\nready\nfoo\n",
+			restarts: 1,
+		},
+		{
+			name:   "always answered",
+			script: `echo ready; read l; echo "$l"`,
+			policy: RestartAlways,
+			stdout: "ready\nfoo\n",
+		},
+	}
+	for _, tt := range testdata {
+		opts := Options{
+			Command:        []string{"sh", "-c", tt.script},
+			SplitFunc:      split.ByRE(regexp.MustCompile("\n")),
+			Timeout:        time.Second,
+			Restart:        tt.policy,
+			RestartBackoff: time.Millisecond,
+			Stats:          stats.New(0, 0),
+		}
+		os.Setenv("F", filepath.Join(dir, tt.name))
+		e, err := New(opts)
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		stdout := new(syncBuffer)
+		e.stdout = stdout
+		e.log = ioutil.Discard
+		if err := e.Start(); err != nil {
+			t.Fatalf("Start(%v) error = %v", tt.name, err)
+		}
+		if err := e.Wait(); err != nil {
+			t.Fatalf("Wait(%v) error = %v", tt.name, err)
+		}
+		if _, err := e.Write([]byte("foo\n")); err != nil {
+			t.Fatalf("Write(%v) error = %v", tt.name, err)
+		}
+		if err := e.DoneRead(); err != nil {
+			t.Errorf("DoneRead(%v) error = %v", tt.name, err)
+		}
+		if err := e.Stop(); (err != nil) != tt.err {
+			t.Errorf("Stop(%v) error = %v, want error = %v", tt.name, err, tt.err)
+		}
+		if got := string(stdout.take()); got != tt.stdout {
+			t.Errorf("stdout(%v) = %q, want %q", tt.name, got, tt.stdout)
+		}
+		if got := opts.Stats.Snapshot().Restarts; got != tt.restarts {
+			t.Errorf("Stats(%v) restarts = %v, want %v", tt.name, got, tt.restarts)
+		}
+	}
+}
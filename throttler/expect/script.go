@@ -0,0 +1,289 @@
+package expect
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ErrNoChunkSteps is returned when an expect script has no steps to run for each chunk of data.
+var ErrNoChunkSteps = errors.New("no chunk steps")
+
+// A Script is a dialogue with the wrapped command, a sequence of send/expect steps run for each chunk of data.
+//
+// An expect script has one step per line, blank lines and lines starting with # are ignored.
+// Steps following a "start" line are run once the wrapped command has started (e.g., to wait for its initial prompt),
+// steps following a "chunk" line (or not following any section) are run for each chunk of data:
+//
+//	start
+//	expect `> $`
+//	chunk
+//	send "{{.Chunk}}"
+//	expect `Confirm\? \[y/N\] $`
+//	send "y\n"
+//	expect 5s `> $`
+//
+// "send" writes a text/template to the wrapped command,
+// {{.Chunk}} is the current chunk of data and {{index .Groups N}} is the Nth capture group of the last "expect" step,
+// {{.Header}} is the input's header, if any (see Options.Header), which isn't written automatically in script mode.
+// "expect" waits until the wrapped command's output matches a regular expression, with an optional timeout,
+// only the output's last Options.MaxChunkSize bytes since the last match are matched,
+// it fails with io.ErrUnexpectedEOF if the wrapped command exits first.
+// Arguments are Go string literals, either double-quoted or backquoted.
+type Script struct {
+	start []step
+	chunk []step
+}
+
+// A step is a single script step, either a send or an expect step.
+type step struct {
+	send    *template.Template
+	expect  *regexp.Regexp
+	timeout time.Duration
+}
+
+// scriptData is the data available to send step templates.
+type scriptData struct {
+	Chunk  string
+	Groups []string
+	Header string
+}
+
+// LoadScript parses an expect script file.
+func LoadScript(path string) (*Script, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseScript(f)
+}
+
+// ParseScript parses an expect script from a reader.
+func ParseScript(r io.Reader) (*Script, error) {
+	s := new(Script)
+	steps := &s.chunk
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cmd, arg := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch cmd {
+		case "start":
+			steps = &s.start
+			continue
+		case "chunk":
+			steps = &s.chunk
+			continue
+		}
+		st, err := parseStep(cmd, arg)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", n, err)
+		}
+		*steps = append(*steps, st)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.chunk) == 0 {
+		return nil, ErrNoChunkSteps
+	}
+	return s, nil
+}
+
+func parseStep(cmd, arg string) (step, error) {
+	var st step
+	switch cmd {
+	case "send":
+		lit, err := strconv.Unquote(arg)
+		if err != nil {
+			return st, fmt.Errorf("send %v: %w", arg, err)
+		}
+		st.send, err = template.New("send").Option("missingkey=error").Parse(lit)
+		return st, err
+	case "expect":
+		if arg != "" && arg[0] != '"' && arg[0] != '`' {
+			i := strings.IndexAny(arg, " \t")
+			if i < 0 {
+				return st, fmt.Errorf("expect %v: missing regular expression", arg)
+			}
+			var err error
+			if st.timeout, err = time.ParseDuration(arg[:i]); err != nil {
+				return st, err
+			}
+			arg = strings.TrimSpace(arg[i+1:])
+		}
+		lit, err := strconv.Unquote(arg)
+		if err != nil {
+			return st, fmt.Errorf("expect %v: %w", arg, err)
+		}
+		st.expect, err = regexp.Compile(lit)
+		return st, err
+	default:
+		return st, fmt.Errorf("unknown step %q", cmd)
+	}
+}
+
+// scriptReader reads from the wrapped command's stdout/stderr,
+// writes the output to *this* command's corresponding stdout/stderr and buffers it for expect steps,
+// notifies `found` when there's new output, notifies `errc` on error, closes `done` when there's nothing left to read.
+func (e *Expect) scriptReader() {
+	defer close(e.done)
+	b := make([]byte, 4096)
+	for {
+		n, err := e.r.Read(b)
+		if n > 0 {
+			if _, err := e.tee.Write(b[:n]); err != nil {
+				e.errc <- err
+				return
+			}
+			e.buffer(b[:n])
+			select {
+			case e.found <- struct{}{}:
+			default:
+			}
+		}
+		if err == io.EOF {
+			e.errc <- nil
+			return
+		}
+		if err != nil {
+			e.errc <- err
+			return
+		}
+	}
+}
+
+// buffer appends output to the buffer for expect steps,
+// only the last Options.MaxChunkSize bytes are kept so that output that's never matched can't pile up.
+func (e *Expect) buffer(b []byte) {
+	e.bufMu.Lock()
+	defer e.bufMu.Unlock()
+	e.buf = append(e.buf, b...)
+	if max := e.maxChunkSize(); len(e.buf) > max {
+		e.buf = append(e.buf[:0], e.buf[len(e.buf)-max:]...)
+	}
+}
+
+// match returns the capture groups of the first match of a regular expression in the buffered output, nil if there's none.
+// The buffered output is consumed up to the end of the match.
+func (e *Expect) match(re *regexp.Regexp) []string {
+	e.bufMu.Lock()
+	defer e.bufMu.Unlock()
+	m := re.FindSubmatchIndex(e.buf)
+	if m == nil {
+		return nil
+	}
+	groups := make([]string, len(m)/2)
+	for i := range groups {
+		if m[2*i] >= 0 {
+			groups[i] = string(e.buf[m[2*i]:m[2*i+1]])
+		}
+	}
+	e.buf = append(e.buf[:0], e.buf[m[1]:]...)
+	return groups
+}
+
+// expect waits until the wrapped command's output matches a regular expression, returns the match's capture groups.
+// If timeout <= 0 then the throttler's timeout is used.
+func (e *Expect) expect(re *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	if timeout <= 0 {
+		timeout = e.opts.Timeout
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	for {
+		if groups := e.match(re); groups != nil {
+			return groups, nil
+		}
+		if e.isClosed() {
+			return nil, ErrClosed
+		}
+		select {
+		case <-e.found:
+		case err := <-e.errc:
+			e.setClosed()
+			// Output may have been buffered right before the reader was done.
+			if groups := e.match(re); groups != nil {
+				return groups, nil
+			}
+			if err == nil {
+				err = fmt.Errorf("%w: %v exited before its output matched %v", io.ErrUnexpectedEOF, e.opts.Command[0], re)
+			}
+			return nil, err
+		case <-expired:
+			return nil, ErrTimeout
+		}
+	}
+}
+
+// run runs a sequence of script steps for a chunk of data.
+func (e *Expect) run(steps []step, chunk []byte) error {
+	d := scriptData{Chunk: string(chunk)}
+	if e.opts.Header != nil {
+		d.Header = string(e.opts.Header())
+	}
+	for _, st := range steps {
+		if st.send != nil {
+			var b bytes.Buffer
+			if err := st.send.Execute(&b, d); err != nil {
+				return err
+			}
+			if b.Len() > 0 {
+				e.last = b.Bytes()[b.Len()-1]
+			}
+			if _, err := e.w.Write(b.Bytes()); err != nil {
+				return err
+			}
+			continue
+		}
+		groups, err := e.expect(st.expect, st.timeout)
+		if err != nil || e.isClosed() {
+			return err
+		}
+		d.Groups = groups
+	}
+	return nil
+}
+
+// waitScript runs the script's start steps if the wrapped command was just started,
+// then its chunk steps if a chunk of data has been written since.
+func (e *Expect) waitScript() error {
+	e.scriptMu.Lock()
+	defer e.scriptMu.Unlock()
+	if !e.started {
+		e.started = true
+		if err := e.run(e.opts.Script.start, nil); err != nil || e.isClosed() {
+			return err
+		}
+	}
+	if !e.pending {
+		return nil
+	}
+	e.pending = false
+	return e.run(e.opts.Script.chunk, e.cur)
+}
+
+// isPending indicates whether a chunk of data has been written but its script steps haven't been run yet.
+func (e *Expect) isPending() bool {
+	e.scriptMu.Lock()
+	defer e.scriptMu.Unlock()
+	return e.pending
+}
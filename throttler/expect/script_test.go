@@ -0,0 +1,190 @@
+package expect
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/split"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+const confirmScript = `
+# Wait for the initial prompt.
+start
+expect "> $"
+
+chunk
+send "{{.Chunk}}"
+expect ` + "`Confirm (\\w+)\\? \\[y/N\\] $`" + `
+send "y {{index .Groups 1}}\n"
+expect 1s "> $"
+`
+
+// confirmCmd asks for confirmation of each line of input.
+const confirmCmd = `printf '> '; while read l; do printf 'Confirm %s? [y/N] ' "$l"; read a; echo "$a"; printf '> '; done`
+
+func TestParseScript(t *testing.T) {
+	testdata := []struct {
+		name   string
+		script string
+		start  int
+		chunk  int
+		ok     bool
+	}{
+		{
+			name:   "good",
+			script: confirmScript,
+			start:  1,
+			chunk:  4,
+			ok:     true,
+		},
+		{
+			name:   "no section",
+			script: "send `{{.Chunk}}`\nexpect \"\\n\"",
+			chunk:  2,
+			ok:     true,
+		},
+		{
+			name:   "no chunk steps",
+			script: "start\nexpect \"> \"",
+		},
+		{
+			name:   "unknown step",
+			script: "bogus \"foo\"",
+		},
+		{
+			name:   "unquoted",
+			script: "send foo",
+		},
+		{
+			name:   "bad template",
+			script: "send \"{{.Chunk\"",
+		},
+		{
+			name:   "bad regexp",
+			script: "expect \"?bad\"",
+		},
+		{
+			name:   "bad timeout",
+			script: "expect 5x \"> \"",
+		},
+		{
+			name:   "missing regexp",
+			script: "expect 5s",
+		},
+	}
+	for _, tt := range testdata {
+		s, err := ParseScript(strings.NewReader(tt.script))
+		if err != nil {
+			if tt.ok {
+				t.Errorf("ParseScript(%v) error = %v", tt.name, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("ParseScript(%v) error = nil", tt.name)
+			continue
+		}
+		if diff := pretty.Compare([]int{len(s.start), len(s.chunk)}, []int{tt.start, tt.chunk}); diff != "" {
+			t.Errorf("ParseScript(%v) steps -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	testdata := []struct {
+		name    string
+		cmd     string
+		timeout time.Duration
+		chunks  []string
+		stdout  string
+		err     error
+	}{
+		{
+			name:   "good",
+			cmd:    confirmCmd,
+			chunks: []string{"foo\n", "bar\n"},
+			stdout: "> Confirm foo? [y/N] y foo\n> Confirm bar? [y/N] y bar\n> ",
+		},
+		{
+			name:    "timeout",
+			cmd:     `printf '> '; read l; exec sleep 10`,
+			timeout: 100 * time.Millisecond,
+			chunks:  []string{"foo\n"},
+			stdout:  "> ",
+			err:     ErrTimeout,
+		},
+		{
+			name:   "exited",
+			cmd:    `printf '> '; read l`,
+			chunks: []string{"foo\n"},
+			stdout: "> ",
+			err:    io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range testdata {
+		s, err := ParseScript(strings.NewReader(confirmScript))
+		if err != nil {
+			t.Fatalf("ParseScript(%v) error = %v", tt.name, err)
+		}
+		e, err := New(Options{
+			Command:   []string{"sh", "-c", tt.cmd},
+			SplitFunc: split.ByRE(regexp.MustCompile("\n")),
+			Timeout:   tt.timeout,
+			Script:    s,
+		})
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		stdout := new(syncBuffer)
+		e.stdout = stdout
+		e.log = ioutil.Discard
+		if err := e.Start(); err != nil {
+			t.Fatalf("Start(%v) error = %v", tt.name, err)
+		}
+		err = e.Wait()
+		for i, chunk := range tt.chunks {
+			if err != nil {
+				break
+			}
+			if _, err = e.Write([]byte(chunk)); err != nil {
+				break
+			}
+			// The runner doesn't wait after the last chunk of data, DoneRead runs its script instead.
+			if i < len(tt.chunks)-1 {
+				err = e.Wait()
+			}
+		}
+		if err == nil {
+			err = e.DoneRead()
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Wait(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		e.Stop()
+		if got := string(stdout.take()); got != tt.stdout {
+			t.Errorf("stdout(%v) = %q, want %q", tt.name, got, tt.stdout)
+		}
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	e := &Expect{opts: Options{MaxChunkSize: 4}}
+	for _, s := range []string{"ab", "cdef", "g"} {
+		e.buffer([]byte(s))
+	}
+	if got, want := string(e.buf), "defg"; got != want {
+		t.Errorf("buffer() = %q, want %q", got, want)
+	}
+	if diff := pretty.Compare(e.match(regexp.MustCompile(`e(f)`)), []string{"ef", "f"}); diff != "" {
+		t.Errorf("match() -got +want:\n%v", diff)
+	}
+	if got, want := string(e.buf), "g"; got != want {
+		t.Errorf("buffer() after match = %q, want %q", got, want)
+	}
+}
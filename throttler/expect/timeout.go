@@ -0,0 +1,180 @@
+package expect
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ErrBadPolicy is returned when parsing an unknown timeout policy.
+var ErrBadPolicy = errors.New("unknown timeout policy")
+
+// A Policy is what to do when the wrapped command doesn't output matching text in time.
+type Policy int
+
+const (
+	// Abort kills the wrapped command and returns ErrTimeout.
+	Abort Policy = iota
+
+	// Skip gives up on the current chunk of data and moves on to the next one.
+	// The wrapped command keeps running, any matching text it outputs late counts towards the next chunk.
+	Skip
+
+	// Retry re-sends the current chunk of data to the wrapped command.
+	Retry
+
+	// Restart kills and respawns the wrapped command, waits for its initial prompt
+	// and re-sends the current chunk of data.
+	Restart
+)
+
+var policies = map[string]Policy{
+	"abort":   Abort,
+	"skip":    Skip,
+	"retry":   Retry,
+	"restart": Restart,
+}
+
+// ParsePolicy parses a timeout policy by name.
+func ParsePolicy(s string) (Policy, error) {
+	p, ok := policies[s]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrBadPolicy, s)
+	}
+	return p, nil
+}
+
+func (p Policy) String() string {
+	for s, v := range policies {
+		if v == p {
+			return s
+		}
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// kill kills the wrapped command along with any processes it forked, if it was started and hasn't been reaped yet,
+// these may otherwise keep its output open.
+func (e *Expect) kill() {
+	e.procMu.Lock()
+	defer e.procMu.Unlock()
+	if e.cmd.Process == nil || e.reaped {
+		return
+	}
+	if e.group {
+		killGroup(e.cmd.Process)
+	} else {
+		e.cmd.Process.Kill()
+	}
+}
+
+// KillGroup kills the wrapped command along with any processes it forked if it runs in its own process group,
+// e.g., when this process is interrupted, as signals sent to this process' group don't reach it.
+// A wrapped command in this process' group is left to be signaled along with it.
+func (e *Expect) KillGroup() {
+	e.procMu.Lock()
+	defer e.procMu.Unlock()
+	if e.group && e.cmd.Process != nil && !e.reaped {
+		killGroup(e.cmd.Process)
+	}
+}
+
+// restart kills and respawns the wrapped command, any of its remaining output is let through.
+// procMu is held while it's respawned so that its input can't be closed in the meantime.
+func (e *Expect) restart() error {
+	e.kill()
+	e.reap()
+	e.scriptMu.Lock()
+	e.started = false
+	e.pending = false
+	e.scriptMu.Unlock()
+	e.bufMu.Lock()
+	e.buf = nil
+	e.failure = ""
+	e.succeeded = false
+	e.written = false
+	e.bufMu.Unlock()
+	e.procMu.Lock()
+	defer e.procMu.Unlock()
+	e.cmd = exec.Command(e.opts.Command[0], e.opts.Command[1:]...)
+	e.r, e.w, e.pty, e.tty = nil, nil, nil, nil
+	e.last = 0
+	e.closed = false
+	e.reaping = nil
+	e.reaped = false
+	e.drained = false
+	e.headed = false
+	e.done = make(chan struct{})
+	e.found = make(chan struct{}, 1)
+	e.errc = make(chan error, 1)
+	e.opts.Stats.AddRestart()
+	if err := e.Start(); err != nil {
+		e.closed = true
+		return err
+	}
+	return nil
+}
+
+// resend writes the current chunk of data again, if any,
+// the wrapped command's input is closed again if DoneRead was already called.
+func (e *Expect) resend() error {
+	if e.cur == nil {
+		return nil
+	}
+	if _, err := e.Write(e.cur); err != nil {
+		return err
+	}
+	if e.inputClosed() {
+		return e.closeInput()
+	}
+	return nil
+}
+
+// onTimeout applies the timeout policy after waiting for the wrapped command timed out.
+func (e *Expect) onTimeout() error {
+	e.opts.Stats.AddTimeout()
+	if e.opts.OnTimeout == Skip {
+		fmt.Fprintf(e.log, "timed out waiting for %v, skipping chunk\n", e.opts.Command[0])
+		return nil
+	}
+	if e.opts.OnTimeout == Retry || e.opts.OnTimeout == Restart {
+		retries := e.opts.Retries
+		if retries <= 0 {
+			retries = 1
+		}
+		backoff := e.opts.Backoff
+		for i := 1; i <= retries; i++ {
+			fmt.Fprintf(e.log, "timed out waiting for %v, %v (%v/%v)\n", e.opts.Command[0], e.opts.OnTimeout, i, retries)
+			time.Sleep(backoff)
+			backoff *= 2
+			err := e.retry()
+			if !errors.Is(err, ErrTimeout) {
+				return err
+			}
+			e.opts.Stats.AddTimeout()
+		}
+		fmt.Fprintf(e.log, "timed out waiting for %v, giving up\n", e.opts.Command[0])
+	}
+	e.kill()
+	return ErrTimeout
+}
+
+// retry makes a single attempt at getting the wrapped command to respond to the current chunk of data.
+func (e *Expect) retry() error {
+	if e.opts.OnTimeout == Restart {
+		if err := e.restart(); err != nil {
+			return err
+		}
+		if e.cur != nil {
+			// The respawned command's initial prompt.
+			if err := e.wait(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := e.resend(); err != nil {
+		return err
+	}
+	return e.wait()
+}
@@ -0,0 +1,172 @@
+package expect
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/split"
+	"github.com/hazaelsan/pipe-throttler/stats"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParsePolicy(t *testing.T) {
+	testdata := []struct {
+		s    string
+		want Policy
+		err  error
+	}{
+		{s: "abort", want: Abort},
+		{s: "skip", want: Skip},
+		{s: "retry", want: Retry},
+		{s: "restart", want: Restart},
+		{s: "bogus", err: ErrBadPolicy},
+	}
+	for _, tt := range testdata {
+		got, err := ParsePolicy(tt.s)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParsePolicy(%v) error = %v, want %v", tt.s, err, tt.err)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("ParsePolicy(%v) = %v, want %v", tt.s, got, tt.want)
+		}
+		if err == nil && got.String() != tt.s {
+			t.Errorf("String(%v) = %v", tt.s, got)
+		}
+	}
+}
+
+func TestOnTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "expect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testdata := []struct {
+		name     string
+		script   string
+		policy   Policy
+		retries  int
+		err      error
+		stdout   string
+		timeouts int64
+		restarts int64
+	}{
+		{
+			name:     "abort",
+			script:   `echo ready; read l; exec sleep 10`,
+			policy:   Abort,
+			err:      ErrTimeout,
+			stdout:   "ready\n",
+			timeouts: 1,
+		},
+		{
+			// The shell forks sleep, which holds on to the wrapped command's stdout.
+			name:     "abort forked",
+			script:   `echo ready; read l; sleep 10; true`,
+			policy:   Abort,
+			err:      ErrTimeout,
+			stdout:   "ready\n",
+			timeouts: 1,
+		},
+		{
+			name:     "skip",
+			script:   `echo ready; read l; read l; echo "$l"`,
+			policy:   Skip,
+			stdout:   "ready\nbar\n",
+			timeouts: 1,
+		},
+		{
+			name:     "retry",
+			script:   `echo ready; read l; read l; echo "$l"; read l; echo "$l"`,
+			policy:   Retry,
+			stdout:   "ready\nfoo\nbar\n",
+			timeouts: 1,
+		},
+		{
+			name:     "retries exhausted",
+			script:   `echo ready; read l; read l; read l; exec sleep 10`,
+			policy:   Retry,
+			retries:  2,
+			err:      ErrTimeout,
+			stdout:   "ready\n",
+			timeouts: 3,
+		},
+		{
+			name:     "retries exhausted forked",
+			script:   `echo ready; read l; read l; read l; sleep 10; true`,
+			policy:   Retry,
+			retries:  2,
+			err:      ErrTimeout,
+			stdout:   "ready\n",
+			timeouts: 3,
+		},
+		{
+			name:     "restart",
+			script:   `echo ready; read l; [ -e "$F" ] || { touch "$F"; exec sleep 10; }; echo "$l"; read l; echo "$l"`,
+			policy:   Restart,
+			stdout:   "ready\nready\nfoo\nbar\n",
+			timeouts: 1,
+			restarts: 1,
+		},
+		{
+			name:     "restart forked",
+			script:   `echo ready; read l; [ -e "$F" ] || { touch "$F"; sleep 10; true; }; echo "$l"; read l; echo "$l"`,
+			policy:   Restart,
+			stdout:   "ready\nready\nfoo\nbar\n",
+			timeouts: 1,
+			restarts: 1,
+		},
+	}
+	for _, tt := range testdata {
+		opts := Options{
+			Command:   []string{"sh", "-c", tt.script},
+			SplitFunc: split.ByRE(regexp.MustCompile("\n")),
+			Timeout:   100 * time.Millisecond,
+			OnTimeout: tt.policy,
+			Retries:   tt.retries,
+			Backoff:   time.Millisecond,
+			Stats:     stats.New(0, 0),
+		}
+		os.Setenv("F", filepath.Join(dir, tt.name))
+		e, err := New(opts)
+		if err != nil {
+			t.Fatalf("New(%v) error = %v", tt.name, err)
+		}
+		stdout := new(syncBuffer)
+		e.stdout = stdout
+		e.log = ioutil.Discard
+		if err := e.Start(); err != nil {
+			t.Fatalf("Start(%v) error = %v", tt.name, err)
+		}
+		err = e.Wait()
+		for _, chunk := range []string{"foo\n", "bar\n"} {
+			if err != nil {
+				break
+			}
+			if _, err = e.Write([]byte(chunk)); err != nil {
+				break
+			}
+			err = e.Wait()
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Wait(%v) error = %v, want %v", tt.name, err, tt.err)
+		}
+		if err == nil {
+			e.DoneRead()
+		}
+		e.Stop()
+		if got := string(stdout.take()); got != tt.stdout {
+			t.Errorf("stdout(%v) = %q, want %q", tt.name, got, tt.stdout)
+		}
+		s := opts.Stats.Snapshot()
+		if diff := pretty.Compare([]int64{s.Timeouts, s.Restarts}, []int64{tt.timeouts, tt.restarts}); diff != "" {
+			t.Errorf("Stats(%v) timeouts, restarts -got +want:\n%v", tt.name, diff)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Package ratelimit implements a token bucket throttler.
+// It limits the throughput of another throttler by chunk count and/or by bytes.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/stats"
+	"github.com/hazaelsan/pipe-throttler/throttler"
+)
+
+// Options is a set of options to instantiate a RateLimit throttler.
+type Options struct {
+	// Rate is the maximum number of chunks per second, unlimited if <= 0.
+	Rate float64
+
+	// ByteRate is the maximum number of bytes per second, unlimited if <= 0.
+	ByteRate float64
+
+	// Burst is the chunk bucket size for Rate.
+	// If <= 0 then it holds one second's worth of chunks (at least one).
+	Burst int
+
+	// ByteBurst is the byte bucket size for ByteRate.
+	// If <= 0 then it holds one second's worth of bytes (at least one).
+	ByteBurst int
+
+	// Stats, if set, keeps track of time spent waiting for tokens, it's only used by New.
+	Stats *stats.Stats
+}
+
+// New instantiates a RateLimit throttler wrapping another throttler.
+// If no limits are set then data is written through unthrottled until limits are set via SetLimits.
+func New(t throttler.Throttler, opts Options) *RateLimit {
+	r := &RateLimit{
+		t:     t,
+		stats: opts.Stats,
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+	r.setLimits(opts)
+	return r
+}
+
+// A RateLimit is a token bucket throttler.
+// Each chunk consumes one token from the chunk bucket and one token per byte from the byte bucket,
+// a chunk larger than the bucket size is allowed through once the bucket is full,
+// leaving the bucket in debt so that the long-term rate is preserved.
+type RateLimit struct {
+	t      throttler.Throttler
+	mu     sync.Mutex
+	chunks *bucket
+	bytes  *bucket
+	stats  *stats.Stats
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// SetLimits changes the throttler limits, it is safe to call while the throttler is running.
+// Buckets keep their current tokens (or debt), capped at the new bucket size, so that changing the limits doesn't allow a burst.
+func (r *RateLimit) SetLimits(opts Options) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setLimits(opts)
+}
+
+func (r *RateLimit) setLimits(opts Options) {
+	now := r.now()
+	r.chunks = newBucket(opts.Rate, opts.Burst, now).carry(r.chunks, now)
+	r.bytes = newBucket(opts.ByteRate, opts.ByteBurst, now).carry(r.bytes, now)
+}
+
+// reserve takes the tokens needed to write n bytes,
+// returns how long to wait before the tokens become available.
+func (r *RateLimit) reserve(n int) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	d := r.chunks.take(1, now)
+	if bd := r.bytes.take(float64(n), now); bd > d {
+		d = bd
+	}
+	return d
+}
+
+// Start starts up the throttler.
+func (r *RateLimit) Start() error {
+	return r.t.Start()
+}
+
+// Stop shuts down the throttler.
+func (r *RateLimit) Stop() error {
+	return r.t.Stop()
+}
+
+// DoneRead indicates that there is no more data to be read into the throttler.
+func (r *RateLimit) DoneRead() error {
+	return r.t.DoneRead()
+}
+
+// Wait blocks until the wrapped throttler can write more data.
+func (r *RateLimit) Wait() error {
+	return r.t.Wait()
+}
+
+// Write waits until enough tokens are available and writes the next chunk of data to the wrapped throttler.
+func (r *RateLimit) Write(b []byte) (int, error) {
+	if d := r.reserve(len(b)); d > 0 {
+		r.sleep(d)
+		r.stats.AddSleep(d)
+	}
+	return r.t.Write(b)
+}
+
+// A bucket is a single token bucket, a nil bucket is unlimited.
+type bucket struct {
+	rate   float64
+	size   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64, burst int, now time.Time) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	size := float64(burst)
+	if burst <= 0 {
+		size = math.Max(math.Ceil(rate), 1)
+	}
+	return &bucket{
+		rate:   rate,
+		size:   size,
+		tokens: size,
+		last:   now,
+	}
+}
+
+// carry carries over the tokens left in an old bucket as of now, capped at the bucket's size,
+// the bucket is left full if the old bucket is unlimited.
+func (b *bucket) carry(old *bucket, now time.Time) *bucket {
+	if b == nil || old == nil {
+		return b
+	}
+	old.refill(now)
+	b.tokens = math.Min(b.size, old.tokens)
+	return b
+}
+
+// refill adds the tokens accrued since the last refill.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.size, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// take removes n tokens from the bucket,
+// returns how long to wait until the bucket is no longer in debt.
+func (b *bucket) take(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+	// Chunks larger than the bucket only need to wait for a full bucket.
+	need := math.Min(n, b.size)
+	var d time.Duration
+	if b.tokens < need {
+		d = time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens -= n
+	return d
+}
@@ -0,0 +1,168 @@
+package ratelimit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hazaelsan/pipe-throttler/stats"
+	"github.com/hazaelsan/pipe-throttler/throttler/dummy"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+type writeCloser struct {
+	strings.Builder
+}
+
+func (*writeCloser) Close() error {
+	return nil
+}
+
+// clock is a fake clock, sleeping advances the current time.
+type clock struct {
+	t      time.Time
+	sleeps []time.Duration
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) sleep(d time.Duration) {
+	c.sleeps = append(c.sleeps, d)
+	c.t = c.t.Add(d)
+}
+
+func newRateLimit(t *testing.T, opts Options) (*RateLimit, *clock, *writeCloser) {
+	t.Helper()
+	wc := new(writeCloser)
+	r := New(dummy.New(wc), opts)
+	c := &clock{t: time.Unix(0, 0)}
+	r.now = c.now
+	r.sleep = c.sleep
+	r.setLimits(opts)
+	return r, c, wc
+}
+
+func TestWrite(t *testing.T) {
+	testdata := []struct {
+		name   string
+		opts   Options
+		chunks []string
+		want   []time.Duration
+	}{
+		{
+			name:   "rate",
+			opts:   Options{Rate: 2},
+			chunks: []string{"a", "b", "c", "d"},
+			want:   []time.Duration{500 * time.Millisecond, 500 * time.Millisecond},
+		},
+		{
+			name:   "rate burst",
+			opts:   Options{Rate: 1, Burst: 3},
+			chunks: []string{"a", "b", "c", "d"},
+			want:   []time.Duration{time.Second},
+		},
+		{
+			name:   "byte rate",
+			opts:   Options{ByteRate: 4},
+			chunks: []string{"foo\n", "bar\n", "baz\n"},
+			want:   []time.Duration{time.Second, time.Second},
+		},
+		{
+			name:   "oversized chunk",
+			opts:   Options{ByteRate: 2, ByteBurst: 2},
+			chunks: []string{"foo\n", "b"},
+			want:   []time.Duration{1500 * time.Millisecond},
+		},
+		{
+			name:   "unlimited",
+			chunks: []string{"foo\n", "bar\n"},
+		},
+		{
+			name:   "both",
+			opts:   Options{Rate: 10, ByteRate: 4, ByteBurst: 4},
+			chunks: []string{"foo\n", "bar\n"},
+			want:   []time.Duration{time.Second},
+		},
+		{
+			name:   "separate bursts",
+			opts:   Options{Rate: 1, ByteRate: 1024, Burst: 1, ByteBurst: 1024},
+			chunks: []string{"a", "b"},
+			want:   []time.Duration{time.Second},
+		},
+	}
+	for _, tt := range testdata {
+		tt.opts.Stats = stats.New(0, 0)
+		r, c, wc := newRateLimit(t, tt.opts)
+		for _, chunk := range tt.chunks {
+			if _, err := r.Write([]byte(chunk)); err != nil {
+				t.Errorf("Write(%v, %q) error = %v", tt.name, chunk, err)
+			}
+		}
+		if got, want := wc.String(), strings.Join(tt.chunks, ""); got != want {
+			t.Errorf("Write(%v) = %q, want %q", tt.name, got, want)
+		}
+		if diff := pretty.Compare(c.sleeps, tt.want); diff != "" {
+			t.Errorf("Write(%v) sleeps -got +want:\n%v", tt.name, diff)
+		}
+		var want time.Duration
+		for _, d := range tt.want {
+			want += d
+		}
+		if got := tt.opts.Stats.Snapshot().Sleep; got != want {
+			t.Errorf("Write(%v) Stats.Sleep = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	r, c, _ := newRateLimit(t, Options{Rate: 1, Burst: 1})
+	for i := 0; i < 2; i++ {
+		if _, err := r.Write([]byte("a")); err != nil {
+			t.Errorf("Write() error = %v", err)
+		}
+	}
+	r.SetLimits(Options{})
+	for i := 0; i < 2; i++ {
+		if _, err := r.Write([]byte("a")); err != nil {
+			t.Errorf("Write() error = %v", err)
+		}
+	}
+	r.SetLimits(Options{Rate: 4, Burst: 1})
+	for i := 0; i < 2; i++ {
+		if _, err := r.Write([]byte("a")); err != nil {
+			t.Errorf("Write() error = %v", err)
+		}
+	}
+	want := []time.Duration{time.Second, 250 * time.Millisecond}
+	if diff := pretty.Compare(c.sleeps, want); diff != "" {
+		t.Errorf("sleeps -got +want:\n%v", diff)
+	}
+}
+
+func TestSetLimits_noBurst(t *testing.T) {
+	r, c, _ := newRateLimit(t, Options{Rate: 1, Burst: 1})
+	for i := 0; i < 2; i++ {
+		if _, err := r.Write([]byte("a")); err != nil {
+			t.Errorf("Write() error = %v", err)
+		}
+	}
+	// The bucket is empty, a bigger one doesn't start out full.
+	r.SetLimits(Options{Rate: 2, Burst: 5})
+	if _, err := r.Write([]byte("a")); err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+	c.t = c.t.Add(10 * time.Second)
+	// The bucket is full, a smaller one is capped at its size.
+	r.SetLimits(Options{Rate: 1, Burst: 2})
+	for i := 0; i < 3; i++ {
+		if _, err := r.Write([]byte("a")); err != nil {
+			t.Errorf("Write() error = %v", err)
+		}
+	}
+	want := []time.Duration{time.Second, 500 * time.Millisecond, time.Second}
+	if diff := pretty.Compare(c.sleeps, want); diff != "" {
+		t.Errorf("sleeps -got +want:\n%v", diff)
+	}
+}
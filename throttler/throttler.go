@@ -2,7 +2,79 @@
 // Its function is to limit the rate at which to pass output from one file descriptor to another.
 package throttler
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRejected is returned when a chunk of data was rejected by a wrapped command.
+var ErrRejected = errors.New("chunk rejected")
+
+// A Rejection is returned by Wait (or Stop, for the last chunk of data) when a chunk of data was rejected,
+// the throttler is still able to carry on.
+type Rejection struct {
+	// Chunk is the rejected chunk of data.
+	Chunk []byte
+
+	// Reason is why the chunk was rejected, e.g., the wrapped command's error message.
+	Reason string
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("%v: %q", ErrRejected, r.Reason)
+}
+
+// Unwrap makes a Rejection match ErrRejected.
+func (r *Rejection) Unwrap() error {
+	return ErrRejected
+}
+
+// Rejections is returned by Stop when several chunks of data were rejected.
+type Rejections []*Rejection
+
+func (r Rejections) Error() string {
+	var ss []string
+	for _, rej := range r {
+		ss = append(ss, rej.Error())
+	}
+	return strings.Join(ss, "; ")
+}
+
+// Unwrap makes Rejections match ErrRejected.
+func (r Rejections) Unwrap() error {
+	return ErrRejected
+}
+
+// A Header is a header (e.g., a CSV header row) read from the input by a runner,
+// for throttlers to write before the first chunk of data written to each destination.
+// All methods are safe for concurrent use, and are no-ops on a nil *Header.
+type Header struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+// Set sets the header.
+func (h *Header) Set(b []byte) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.b = b
+}
+
+// Get returns the header, nil if it's not set (yet).
+func (h *Header) Get() []byte {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.b
+}
 
 // A Throttler is a stream throttler.
 type Throttler interface {
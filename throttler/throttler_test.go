@@ -71,3 +71,19 @@ func TestWrite(t *testing.T) {
 		}
 	}
 }
+
+func TestHeader(t *testing.T) {
+	var nilHeader *Header
+	nilHeader.Set([]byte("foo"))
+	if got := nilHeader.Get(); got != nil {
+		t.Errorf("Get(nil) = %q, want nil", got)
+	}
+	h := new(Header)
+	if got := h.Get(); got != nil {
+		t.Errorf("Get() = %q, want nil", got)
+	}
+	h.Set([]byte("a,b\n"))
+	if got := string(h.Get()); got != "a,b\n" {
+		t.Errorf("Get() = %q, want %q", got, "a,b\n")
+	}
+}